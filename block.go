@@ -2,38 +2,52 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"fmt"
 	"log"
 	"time"
 )
 
 // Block represents a block in the blockchain
 type Block struct {
-	PrevBlockHash []byte
-	Timestamp     int64
+	BlockHeader
 
 	Hash         []byte
 	Transactions []*Transaction
-	Nonce        int
 	Height       int
+
+	// Signer and Signature are set by consensus engines that sign blocks:
+	// the public key of the signer and its signature of the block hash
+	Signer    []byte
+	Signature []byte
 }
 
-// NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{prevBlockHash, time.Now().Unix(), []byte{}, transactions, 0, height}
+// NewBlock creates and returns Block, sealed by the active consensus engine
+// on a single goroutine so the same inputs always give the same block
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32, timestamp int64) *Block {
+	block := newBlockTemplate(transactions, prevBlockHash, height, bits, timestamp)
+
+	err := activeConsensus.Seal(context.Background(), nil, block, 1)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	pow := NewProofOfWork(block)
-	nonce, hash := pow.Run()
+	return block
+}
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+// newBlockTemplate creates a block that still has to be mined
+func newBlockTemplate(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32, timestamp int64) *Block {
+	header := BlockHeader{blockVersion, prevBlockHash, nil, timestamp, bits, 0}
+	block := &Block{BlockHeader: header, Hash: []byte{}, Transactions: transactions, Height: height}
+	block.MerkleRoot = block.HashTransactions()
 
 	return block
 }
 
 // NewGenesisBlock creates and returns genesis Block
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, activeNetParams.GenesisBits, time.Now().Unix())
 }
 
 // HashTransactions returns a hash of the transactions in the block
@@ -48,6 +62,16 @@ func (b *Block) HashTransactions() []byte {
 	return mTree.RootNode.Data
 }
 
+// Coinstake returns the transaction that stakes coins in a proof-of-stake
+// block, or nil when the block is not one
+func (b *Block) Coinstake() *Transaction {
+	if activeNetParams.Consensus != ConsensusPoS || b.Height == 0 || len(b.Transactions) < 2 {
+		return nil
+	}
+
+	return b.Transactions[1]
+}
+
 // Serialize serializes the block
 func (b *Block) Serialize() []byte {
 	var result bytes.Buffer
@@ -63,13 +87,24 @@ func (b *Block) Serialize() []byte {
 
 // DeserializeBlock deserializes a block
 func DeserializeBlock(d []byte) *Block {
+	block, err := decodeBlock(d)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return block
+}
+
+// decodeBlock deserializes a block received from a peer or a miner, which
+// may be malformed
+func decodeBlock(d []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(d))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("decoding block: %s", err)
 	}
 
-	return &block
+	return &block, nil
 }
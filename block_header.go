@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+const blockVersion = 1
+
+// blockHeaderLen is the size of a serialized header:
+// version(4) | prev block hash(32) | merkle root(32) | timestamp(8) | bits(4) | nonce(4)
+const blockHeaderLen = 84
+
+// nonceOffset is the position of the nonce in a serialized header
+const nonceOffset = blockHeaderLen - 4
+
+// BlockHeader holds the fields of a block that are covered by its hash
+type BlockHeader struct {
+	Version       int32
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          uint32
+	Nonce         uint32
+}
+
+// Serialize returns the fixed-length binary encoding of the header.
+// Integers are big-endian and hashes take 32 bytes each, so the genesis
+// block's empty PrevBlockHash is written as 32 zero bytes.
+func (h *BlockHeader) Serialize() []byte {
+	data := make([]byte, blockHeaderLen)
+
+	binary.BigEndian.PutUint32(data[0:4], uint32(h.Version))
+	copy(data[4:36], h.PrevBlockHash)
+	copy(data[36:68], h.MerkleRoot)
+	binary.BigEndian.PutUint64(data[68:76], uint64(h.Timestamp))
+	binary.BigEndian.PutUint32(data[76:80], h.Bits)
+	binary.BigEndian.PutUint32(data[80:84], h.Nonce)
+
+	return data
+}
+
+// Hash returns the hash of the serialized header
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// DeserializeBlockHeader decodes a header produced by Serialize
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	if len(data) != blockHeaderLen {
+		return nil, fmt.Errorf("block header must be %d bytes, got %d", blockHeaderLen, len(data))
+	}
+
+	header := BlockHeader{
+		Version:       int32(binary.BigEndian.Uint32(data[0:4])),
+		PrevBlockHash: append([]byte{}, data[4:36]...),
+		MerkleRoot:    append([]byte{}, data[36:68]...),
+		Timestamp:     int64(binary.BigEndian.Uint64(data[68:76])),
+		Bits:          binary.BigEndian.Uint32(data[76:80]),
+		Nonce:         binary.BigEndian.Uint32(data[80:84]),
+	}
+
+	if bytes.Equal(header.PrevBlockHash, make([]byte, 32)) {
+		header.PrevBlockHash = []byte{}
+	}
+
+	err := header.checkHashLengths()
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+// checkHashLengths checks that the hashes in the header are 32 bytes long,
+// which Serialize relies on to give every header its own encoding. Only the
+// genesis block has no previous block; its empty PrevBlockHash is written as
+// 32 zero bytes, so that value cannot be used by other blocks.
+func (h *BlockHeader) checkHashLengths() error {
+	if len(h.PrevBlockHash) != 32 && len(h.PrevBlockHash) != 0 {
+		return fmt.Errorf("previous block hash must be 32 bytes, got %d", len(h.PrevBlockHash))
+	}
+	if bytes.Equal(h.PrevBlockHash, make([]byte, 32)) {
+		return fmt.Errorf("previous block hash of zeros is reserved for the genesis block")
+	}
+	if len(h.MerkleRoot) != 32 {
+		return fmt.Errorf("merkle root must be 32 bytes, got %d", len(h.MerkleRoot))
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockHeaderSerialization(t *testing.T) {
+	header := BlockHeader{
+		Version:       blockVersion,
+		PrevBlockHash: []byte{},
+		MerkleRoot:    make([]byte, 32),
+		Timestamp:     1700000000,
+		Bits:          0x1f010000,
+		Nonce:         42,
+	}
+	header.MerkleRoot[0] = 0xab
+
+	data := header.Serialize()
+	assert.Len(t, data, blockHeaderLen)
+	decoded, err := DeserializeBlockHeader(data)
+	assert.Nil(t, err)
+	assert.Equal(t, header, *decoded)
+
+	_, err = DeserializeBlockHeader(data[1:])
+	assert.NotNil(t, err, "A truncated header is rejected")
+
+	header.Nonce++
+	assert.NotEqual(t, data, header.Serialize(), "The nonce is covered by the encoding")
+}
+
+func TestBlockHeaderHashLengths(t *testing.T) {
+	header := BlockHeader{Version: blockVersion, PrevBlockHash: []byte{}, MerkleRoot: make([]byte, 32)}
+	assert.Nil(t, header.checkHashLengths(), "The genesis block has no previous block")
+
+	header.PrevBlockHash = make([]byte, 31)
+	header.PrevBlockHash[0] = 1
+	assert.NotNil(t, header.checkHashLengths())
+	header.PrevBlockHash = make([]byte, 32)
+	assert.NotNil(t, header.checkHashLengths(), "Zeros are the encoding of the genesis block's empty hash")
+	header.PrevBlockHash[0] = 1
+	assert.Nil(t, header.checkHashLengths())
+
+	header.MerkleRoot = header.MerkleRoot[:31]
+	assert.NotNil(t, header.checkHashLengths())
+
+	block := NewBlock([]*Transaction{NewCoinbaseTX("miner", "", 1, 0)}, header.PrevBlockHash[:31], 1, activeNetParams.GenesisBits, time.Now().Unix())
+	err := CheckBlock(block)
+	assert.Equal(t, RejectBadHeader, err.(RuleError).Code, "A block whose short hash would be padded is rejected")
+}
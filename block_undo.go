@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+)
+
+// SpentOutput is an output consumed by a block, together with its position
+// and the UTXO entry data of the transaction that created it
+type SpentOutput struct {
+	Txid       []byte
+	Vout       int
+	Output     TXOutput
+	Height     int
+	IsCoinbase bool
+	Timestamp  int64
+}
+
+// BlockUndo holds the outputs spent by a block, in the order its
+// transactions spent them, so the block can be disconnected from the UTXO set
+type BlockUndo struct {
+	SpentOutputs []SpentOutput
+}
+
+// Serialize serializes BlockUndo
+func (undo BlockUndo) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(undo)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeBlockUndo deserializes BlockUndo
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	var undo BlockUndo
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&undo)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return undo
+}
@@ -2,36 +2,62 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/boltdb/bolt"
 )
 
-const dbFile = "blockchain_%s.db"
 const blocksBucket = "blocks"
+const chainWorkBucket = "chainwork"
 
-// !https://blockchain.info/tx/4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b?show_adv=true
-// !subsidy 是挖出新块的奖励金。在比特币中，实际并没有存储这个数字，
-// !而是基于区块总数进行计算而得：区块总数除以 210000 就是 subsidy。
-// !挖出创世块的奖励是 50 BTC，每挖出 210000 个块后，奖励减半。
-// !在我们的实现中，这个奖励值将会是一个常量（至少目前是）。
-const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+// genesisKey is the key in the blocks bucket that holds the genesis block hash
+const genesisKey = "g"
+
+// chainstateVersionKey is the key in the blocks bucket that holds the format
+// version of the UTXO set. A DB without it predates the versioning.
+const chainstateVersionKey = "v"
+
+// chainstateVersion is the format version of the UTXO set written by this
+// node. The UTXO set of a DB with another version is rebuilt when it is opened.
+// Version 2 has undo data for every block of the chain.
+const chainstateVersion = 2
 
 // Blockchain implements interactions with a DB
 type BlockChain struct {
 	tip []byte
 	db  *bolt.DB
+
+	// tipMu guards tip and tipChanged
+	tipMu      sync.Mutex
+	tipChanged chan struct{}
 }
 
+// ErrTipChanged is returned by MineBlock when another block extended the
+// chain while the block was being mined
+var ErrTipChanged = errors.New("mining cancelled: the chain tip changed")
+
 // CreateBlockchain creates a new blockchain DB
 func CreateBlockChain(address, nodeID string) *BlockChain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	cbtx := NewCoinbaseTX(address, activeNetParams.GenesisCoinbaseData, 0, 0)
+	genesis := NewGenesisBlock(cbtx)
+
+	return CreateBlockChainWithGenesis(genesis, nodeID)
+}
+
+// CreateBlockChainWithGenesis creates a new blockchain DB starting at the
+// given genesis block
+func CreateBlockChainWithGenesis(genesis *Block, nodeID string) *BlockChain {
+	dbFile := fmt.Sprintf(activeNetParams.DBFile, nodeID)
 	if dbExists(dbFile) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
@@ -39,9 +65,6 @@ func CreateBlockChain(address, nodeID string) *BlockChain {
 
 	var tip []byte
 
-	cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-	genesis := NewGenesisBlock(cbtx)
-
 	db, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
 		log.Panic(err)
@@ -64,26 +87,38 @@ func CreateBlockChain(address, nodeID string) *BlockChain {
 		}
 		tip = genesis.Hash
 
+		err = b.Put([]byte(genesisKey), genesis.Hash)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		_, err = tx.CreateBucket([]byte(chainWorkBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+		putChainWork(tx, genesis.Hash, activeConsensus.Work(genesis))
+
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := BlockChain{tip, db}
+	bc := BlockChain{tip: tip, db: db}
 
 	return &bc
 }
 
 // NewBlockchain creates a new Blockchain with genesis Block
 func NewBlockChain(nodeID string) *BlockChain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+	dbFile := fmt.Sprintf(activeNetParams.DBFile, nodeID)
 	if dbExists(dbFile) == false {
 		fmt.Println("No existing blockchain found. Create one first.")
 		os.Exit(1)
 	}
 
 	var tip []byte
+	var version []byte
 	db, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
 		log.Panic(err)
@@ -92,6 +127,33 @@ func NewBlockChain(nodeID string) *BlockChain {
 	err = db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		tip = b.Get([]byte("l"))
+		version = append(version, b.Get([]byte(chainstateVersionKey))...)
+
+		_, err := tx.CreateBucketIfNotExists([]byte(utxoBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		if getChainWork(tx, tip) == nil {
+			indexChainWork(tx, tip)
+		}
+
+		if b.Get([]byte(genesisKey)) == nil {
+			block := DeserializeBlock(b.Get(tip))
+			for len(block.PrevBlockHash) > 0 {
+				block = DeserializeBlock(b.Get(block.PrevBlockHash))
+			}
+
+			err = b.Put([]byte(genesisKey), block.Hash)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
 
 		return nil
 	})
@@ -99,13 +161,40 @@ func NewBlockChain(nodeID string) *BlockChain {
 		log.Panic(err)
 	}
 
-	bc := BlockChain{tip, db}
+	bc := BlockChain{tip: tip, db: db}
+
+	if !bytes.Equal(version, []byte{chainstateVersion}) {
+		fmt.Println("The UTXO set has an outdated format, rebuilding it...")
+		UTXOSet{&bc}.Reindex()
+	}
 
 	return &bc
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *BlockChain) AddBlock(block *Block) {
+// GenesisHash returns the hash of the first block of the chain
+func (bc *BlockChain) GenesisHash() []byte {
+	var hash []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		hash = append(hash, b.Get([]byte(genesisKey))...)
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hash
+}
+
+// AddBlock validates the block and saves it into the blockchain. The block
+// becomes the new tip when its branch carries more cumulative work than the
+// current one, in which case the UTXO set is moved over to the new branch.
+// Nothing is written when the block or its branch breaks a consensus rule.
+func (bc *BlockChain) AddBlock(block *Block) error {
+	var newTip []byte
+
 	err := bc.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockInDb := b.Get(block.Hash)
@@ -114,98 +203,226 @@ func (bc *BlockChain) AddBlock(block *Block) {
 			return nil
 		}
 
+		err := CheckBlock(block)
+		if err != nil {
+			return err
+		}
+
+		err = checkBlockContext(tx, block)
+		if err != nil {
+			return err
+		}
+
 		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		err = b.Put(block.Hash, blockData)
 		if err != nil {
 			log.Panic(err)
 		}
 
+		parentWork := getChainWork(tx, block.PrevBlockHash)
+		work := new(big.Int).Add(parentWork, activeConsensus.Work(block))
+		putChainWork(tx, block.Hash, work)
+
 		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		if work.Cmp(getChainWork(tx, lastHash)) <= 0 {
+			return nil
+		}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			bc.tip = block.Hash
+		err = bc.reorganize(tx, lastHash, block)
+		if err != nil {
+			return err
 		}
 
+		err = b.Put([]byte("l"), block.Hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		newTip = block.Hash
+
 		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	if newTip != nil {
+		bc.setTip(newTip)
 	}
+
+	return nil
 }
 
-// FindTransaction finds a transaction by its ID
-func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
+// TipChanged returns a channel that is closed the next time the tip changes
+func (bc *BlockChain) TipChanged() <-chan struct{} {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
 
-	for {
-		block := bci.Next()
+	if bc.tipChanged == nil {
+		bc.tipChanged = make(chan struct{})
+	}
 
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
+	return bc.tipChanged
+}
+
+// setTip moves the tip and wakes up those waiting on TipChanged
+func (bc *BlockChain) setTip(hash []byte) {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
+	bc.tip = hash
+	if bc.tipChanged != nil {
+		close(bc.tipChanged)
+		bc.tipChanged = nil
+	}
+}
+
+// reorganize moves the UTXO set from the branch ending at lastHash to the
+// branch ending at newTip: blocks are disconnected back to the common
+// ancestor and the blocks of the new branch are checked and connected in
+// order
+func (bc *BlockChain) reorganize(tx *bolt.Tx, lastHash []byte, newTip *Block) error {
+	b := tx.Bucket([]byte(blocksBucket))
+	UTXOSet := UTXOSet{bc}
+
+	oldBlock := DeserializeBlock(b.Get(lastHash))
+	newBlock := newTip
+	var attach []*Block
+	detached := 0
+
+	for bytes.Compare(oldBlock.Hash, newBlock.Hash) != 0 {
+		if oldBlock.Height >= newBlock.Height {
+			err := UTXOSet.disconnect(tx, oldBlock)
+			if err != nil {
+				return err
 			}
+			detached++
+			oldBlock = DeserializeBlock(b.Get(oldBlock.PrevBlockHash))
+		} else {
+			attach = append(attach, newBlock)
+			newBlock = DeserializeBlock(b.Get(newBlock.PrevBlockHash))
 		}
+	}
+
+	for i := len(attach) - 1; i >= 0; i-- {
+		err := checkBlockTransactions(tx, attach[i])
+		if err != nil {
+			return err
+		}
+
+		UTXOSet.update(tx, attach[i])
+	}
+
+	if detached > 0 {
+		fmt.Printf("Chain reorganized at block %x: %d blocks disconnected, %d connected\n", oldBlock.Hash, detached, len(attach))
+	}
+
+	return nil
+}
+
+// medianTimePast returns the median timestamp of the block and up to
+// medianTimeBlocks-1 of its ancestors
+func medianTimePast(tx *bolt.Tx, block *Block) int64 {
+	b := tx.Bucket([]byte(blocksBucket))
+	var timestamps []int64
+
+	for i := 0; i < medianTimeBlocks; i++ {
+		timestamps = append(timestamps, block.Timestamp)
 
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
+		block = DeserializeBlock(b.Get(block.PrevBlockHash))
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
 }
 
-// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
-func (bc *BlockChain) FindUTXO() map[string]TXOutputs {
-	UTXO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
+// getChainWork returns the cumulative work of the chain ending at the block
+// or nil if the block is unknown
+func getChainWork(tx *bolt.Tx, blockHash []byte) *big.Int {
+	b := tx.Bucket([]byte(chainWorkBucket))
 
-	for {
-		block := bci.Next()
+	workData := b.Get(blockHash)
+	if workData == nil {
+		return nil
+	}
 
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				// Was the output spent?
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
+	return new(big.Int).SetBytes(workData)
+}
 
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
-			}
+// indexChainWork computes the cumulative work of every block on the chain
+// ending at tip, for databases created before chain work was tracked
+func indexChainWork(tx *bolt.Tx, tip []byte) {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	var chain []*Block
+	for blockHash := tip; len(blockHash) > 0; {
+		block := DeserializeBlock(b.Get(blockHash))
+		chain = append(chain, block)
+		blockHash = block.PrevBlockHash
+	}
+
+	work := big.NewInt(0)
+	for i := len(chain) - 1; i >= 0; i-- {
+		work = new(big.Int).Add(work, activeConsensus.Work(chain[i]))
+		putChainWork(tx, chain[i].Hash, work)
+	}
+}
+
+func putChainWork(tx *bolt.Tx, blockHash []byte, work *big.Int) {
+	b := tx.Bucket([]byte(chainWorkBucket))
+
+	err := b.Put(blockHash, work.Bytes())
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// FindTransaction finds a transaction by its ID
+func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	var transaction Transaction
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		found, err := findTransaction(tx, b.Get([]byte("l")), ID)
+		if err != nil {
+			return err
 		}
+		transaction = *found
 
-		if len(block.PrevBlockHash) == 0 {
-			break
+		return nil
+	})
+
+	return transaction, err
+}
+
+// findTransaction looks for a transaction in the block with the given hash
+// and its ancestors
+func findTransaction(tx *bolt.Tx, blockHash, ID []byte) (*Transaction, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	for len(blockHash) > 0 {
+		block := DeserializeBlock(b.Get(blockHash))
+
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, ID) == 0 {
+				return tx, nil
+			}
 		}
+
+		blockHash = block.PrevBlockHash
 	}
 
-	return UTXO
+	return nil, errors.New("Transaction is not found")
 }
 
 // Iterator returns a BlockchainIterat
 func (bc *BlockChain) Iterator() *BlockChainIterator {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+
 	iter := &BlockChainIterator{bc.tip, bc.db}
 	return iter
 }
@@ -228,6 +445,25 @@ func (bc *BlockChain) GetBestHeight() int {
 	return lastBlock.Height
 }
 
+// MedianTimePast returns the median timestamp of the tip and the blocks
+// before it, the time lock times of the next block are checked against
+func (bc *BlockChain) MedianTimePast() int64 {
+	var medianTime int64
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		tip := DeserializeBlock(b.Get(b.Get([]byte("l"))))
+		medianTime = medianTimePast(tx, tip)
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return medianTime
+}
+
 // GetBlock finds a block by its hash and returns it
 func (bc *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
@@ -270,84 +506,83 @@ func (bc *BlockChain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
-// MineBlock mines a new block with the provided transactions
-// MintBlock
-func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
+// MineBlock mines a new block with the provided transactions on top of the
+// current tip and adds it to the chain. Mining is cancelled with
+// ErrTipChanged when another block changes the tip in the meantime.
+func (bc *BlockChain) MineBlock(transactions []*Transaction) (*Block, error) {
 	a := time.Now().UnixMilli()
 
-	var lastHash []byte
-	var lastHeight int
-
 	for _, tx := range transactions {
 		// TODO: ignore transaction if it's not valid
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		err := bc.VerifyTransaction(tx)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// 获取最近块的Hash
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
+	tipChanged := bc.TipChanged()
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-		lastHeight = block.Height
+	// 获取最近块的Hash
+	lastHash, height, bits, minTimestamp := bc.nextBlockInfo()
 
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
+	timestamp := timeSource.AdjustedTime()
+	if timestamp < minTimestamp {
+		timestamp = minTimestamp
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		bc.tip = newBlock.Hash
+	newBlock := newBlockTemplate(transactions, lastHash, height, bits, timestamp)
+	err := activeConsensus.Seal(ctx, bc, newBlock, miningThreads)
+	if err == context.Canceled {
+		return nil, ErrTipChanged
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
-	})
+	err = bc.AddBlock(newBlock)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	b := time.Now().UnixMilli()
 	fmt.Printf("add a block using time is %d ms\n\n", b-a)
 
-	return newBlock
+	return newBlock, nil
 }
 
 // SignTransaction signs inputs of a Transaction
-func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return fmt.Errorf("signing transaction %x: input %x: %s", tx.ID, vin.Txid, err)
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
 	tx.Sign(privKey, prevTXs)
+
+	return nil
 }
 
-// VerifyTransaction verifies transaction input signatures
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+// VerifyTransaction verifies transaction input signatures. It fails with a
+// RuleError when an input spends an unknown transaction or a signature is
+// invalid.
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
 	prevTXs := make(map[string]Transaction)
@@ -355,12 +590,16 @@ func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 	for _, vin := range tx.Vin {
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return ruleError(RejectMissingInput, "transaction %x spends unknown transaction %x", tx.ID, vin.Txid)
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.Verify(prevTXs)
+	if !tx.Verify(prevTXs) {
+		return ruleError(RejectBadSignature, "transaction %x has an invalid signature", tx.ID)
+	}
+
+	return nil
 }
 
 func dbExists(dbFile string) bool {
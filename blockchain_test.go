@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBlockChain creates a chain with a genesis block paying the address
+// in a temporary directory
+func newTestBlockChain(t *testing.T, address string) *BlockChain {
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(t.TempDir()))
+
+	bc := CreateBlockChain(address, "test")
+	UTXOSet{bc}.Reindex()
+
+	t.Cleanup(func() {
+		bc.db.Close()
+		os.Chdir(cwd)
+	})
+
+	return bc
+}
+
+// utxoSnapshot returns the serialized UTXO set keyed by transaction ID
+func utxoSnapshot(t *testing.T, bc *BlockChain) map[string]string {
+	snapshot := make(map[string]string)
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			snapshot[string(k)] = string(v)
+			return nil
+		})
+	})
+	assert.Nil(t, err)
+
+	return snapshot
+}
+
+func TestChainReorganization(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	bc := newTestBlockChain(t, string(alice.GetAddress()))
+	UTXOSet := UTXOSet{bc}
+	genesis := bc.tip
+
+	a1, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(alice.GetAddress()), "", 1, 0)})
+	assert.Nil(t, err)
+
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(string(bob.GetAddress()), "", 1, 0)}, genesis, 1, activeNetParams.GenesisBits, a1.Timestamp)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, a1.Hash, bc.tip, "A branch with equal work does not replace the tip")
+	assert.Len(t, UTXOSet.FindUTXO(HashPubKey(alice.PublicKey)), 2)
+
+	b2 := NewBlock([]*Transaction{NewCoinbaseTX(string(bob.GetAddress()), "", 2, 0)}, b1.Hash, 2, activeNetParams.GenesisBits, a1.Timestamp+1)
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, b2.Hash, bc.tip, "The branch with more work becomes the tip")
+	assert.Equal(t, 2, bc.GetBestHeight())
+
+	assert.Len(t, UTXOSet.FindUTXO(HashPubKey(alice.PublicKey)), 1, "The coinbase of the old branch is gone")
+	assert.Len(t, UTXOSet.FindUTXO(HashPubKey(bob.PublicKey)), 2)
+
+	reorganized := utxoSnapshot(t, bc)
+	UTXOSet.Reindex()
+	assert.Equal(t, utxoSnapshot(t, bc), reorganized, "The UTXO set matches one rebuilt from the new branch")
+}
+
+func TestChainstateVersion(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc := newTestBlockChain(t, address)
+	_, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", 1, 0)})
+	assert.Nil(t, err)
+	want := utxoSnapshot(t, bc)
+
+	// A UTXO set in an older format, without a version
+	err = bc.db.Update(func(tx *bolt.Tx) error {
+		for k := range want {
+			err := tx.Bucket([]byte(utxoBucket)).Put([]byte(k), []byte("old format"))
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Bucket([]byte(blocksBucket)).Delete([]byte(chainstateVersionKey))
+	})
+	assert.Nil(t, err)
+	bc.db.Close()
+
+	bc = NewBlockChain("test")
+	defer bc.db.Close()
+	assert.Equal(t, want, utxoSnapshot(t, bc), "The UTXO set is rebuilt on open")
+}
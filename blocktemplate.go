@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// BlockTemplate holds everything a miner needs to build and solve the next
+// block without access to the chain: the header fields, the target, the
+// transactions to include and the value the coinbase may claim
+type BlockTemplate struct {
+	Version       int32                 `json:"version"`
+	PrevBlockHash string                `json:"previousblockhash"`
+	Height        int                   `json:"height"`
+	CurTime       int64                 `json:"curtime"`
+	MinTime       int64                 `json:"mintime"`
+	Bits          string                `json:"bits"`
+	Target        string                `json:"target"`
+	Transactions  []TemplateTransaction `json:"transactions"`
+	CoinbaseValue int                   `json:"coinbasevalue"`
+}
+
+// TemplateTransaction is a transaction of a BlockTemplate, serialized as hex
+type TemplateTransaction struct {
+	TxID string `json:"txid"`
+	Data string `json:"data"`
+}
+
+// nextBlockInfo returns what a block built on the current tip has to use:
+// the tip's hash, the new height, the required bits and the earliest
+// timestamp allowed
+func (bc *BlockChain) nextBlockInfo() ([]byte, int, uint32, int64) {
+	var prevHash []byte
+	var height int
+	var bits uint32
+	var minTimestamp int64
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		prevHash = append(prevHash, b.Get([]byte("l"))...)
+		tip := DeserializeBlock(b.Get(prevHash))
+
+		height = tip.Height + 1
+		bits = activeConsensus.RequiredBits(tx, tip)
+		minTimestamp = medianTimePast(tx, tip) + 1
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return prevHash, height, bits, minTimestamp
+}
+
+// GetBlockTemplate builds a template for the next block from the current tip
+// and the mempool
+func (bc *BlockChain) GetBlockTemplate() *BlockTemplate {
+	prevHash, height, bits, minTimestamp := bc.nextBlockInfo()
+	txs, fees := selectMempoolTransactions(bc)
+
+	curTime := timeSource.AdjustedTime()
+	if curTime < minTimestamp {
+		curTime = minTimestamp
+	}
+
+	template := &BlockTemplate{
+		Version:       blockVersion,
+		PrevBlockHash: hex.EncodeToString(prevHash),
+		Height:        height,
+		CurTime:       curTime,
+		MinTime:       minTimestamp,
+		Bits:          fmt.Sprintf("%08x", bits),
+		Target:        fmt.Sprintf("%064x", CompactToBig(bits)),
+		CoinbaseValue: GetBlockSubsidy(height) + fees,
+	}
+
+	for _, tx := range txs {
+		template.Transactions = append(template.Transactions, TemplateTransaction{
+			TxID: hex.EncodeToString(tx.ID),
+			Data: hex.EncodeToString(tx.Serialize()),
+		})
+	}
+
+	return template
+}
+
+// NewBlock builds the unsolved block of the template, with the coinbase
+// placed before the template's transactions
+func (t *BlockTemplate) NewBlock(coinbase *Transaction) (*Block, error) {
+	prevHash, err := hex.DecodeString(t.PrevBlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid previous block hash %q", t.PrevBlockHash)
+	}
+
+	bits, err := strconv.ParseUint(t.Bits, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bits %q", t.Bits)
+	}
+
+	txs := []*Transaction{coinbase}
+	for _, templateTx := range t.Transactions {
+		data, err := hex.DecodeString(templateTx.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction %s", templateTx.TxID)
+		}
+
+		tx, err := decodeTransaction(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction %s: %s", templateTx.TxID, err)
+		}
+		txs = append(txs, &tx)
+	}
+
+	block := newBlockTemplate(txs, prevHash, t.Height, uint32(bits), t.CurTime)
+	block.Version = t.Version
+
+	return block, nil
+}
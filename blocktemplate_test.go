@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockTemplateNewBlock(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	tx := NewCoinbaseTXWithValue(address, "some transaction", 5)
+	template := BlockTemplate{
+		Version:       blockVersion,
+		PrevBlockHash: "00ff",
+		Height:        7,
+		CurTime:       1700000000,
+		Bits:          "1f010000",
+		Transactions:  []TemplateTransaction{{hex.EncodeToString(tx.ID), hex.EncodeToString(tx.Serialize())}},
+		CoinbaseValue: 12,
+	}
+
+	coinbase := NewCoinbaseTXWithValue(address, "", template.CoinbaseValue)
+	block, err := template.NewBlock(coinbase)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []byte{0x00, 0xff}, block.PrevBlockHash)
+	assert.Equal(t, 7, block.Height)
+	assert.Equal(t, int64(1700000000), block.Timestamp)
+	assert.Equal(t, uint32(0x1f010000), block.Bits)
+	assert.Len(t, block.Transactions, 2)
+	assert.Equal(t, coinbase.ID, block.Transactions[0].ID, "The coinbase comes first")
+	assert.Equal(t, tx.ID, block.Transactions[1].ID)
+	assert.Equal(t, block.HashTransactions(), block.MerkleRoot)
+	assert.Equal(t, 12, block.Transactions[0].Vout[0].Value)
+
+	template.Bits = "zz"
+	_, err = template.NewBlock(coinbase)
+	assert.NotNil(t, err, "Invalid bits are rejected")
+
+	template.Bits = "1f010000"
+	template.Transactions[0].Data = "00ff"
+	_, err = template.NewBlock(coinbase)
+	assert.NotNil(t, err, "Undecodable transactions are rejected")
+}
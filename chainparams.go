@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	p2pprotocol "github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ChainParams defines a network: the consensus rules its blocks follow, the
+// way its addresses are encoded and how its nodes find each other
+type ChainParams struct {
+	Name string
+
+	// Files the node keeps its data in, formatted with the node ID
+	DBFile     string
+	WalletFile string
+	PeerDBPath string
+
+	// AddressVersion is the version byte that prefixes pubkey hash addresses
+	AddressVersion byte
+	// ScriptAddressVersion is the version byte that prefixes script hash
+	// addresses
+	ScriptAddressVersion byte
+
+	NodeVersion int
+	ProtocolID  p2pprotocol.ID
+	Rendezvous  string
+
+	GenesisCoinbaseData string
+
+	// GenesisBits is the difficulty of the genesis block in compact form
+	GenesisBits uint32
+	// PowLimitBits is the easiest difficulty a block may have
+	PowLimitBits uint32
+	PowLimit     *big.Int
+	// TargetSpacing is the desired time between blocks in seconds
+	TargetSpacing int64
+	// RetargetInterval is the number of blocks between difficulty changes
+	RetargetInterval int
+	// RetargetClamp bounds how much the difficulty may change in one step
+	RetargetClamp int64
+	// NoRetargeting keeps the difficulty of the genesis block forever
+	NoRetargeting bool
+
+	// InitialSubsidy is the reward for mining a block before the first halving
+	InitialSubsidy int
+	// SubsidyHalvingInterval is the number of blocks after which the reward
+	// is cut in half
+	SubsidyHalvingInterval int
+	// CoinbaseMaturity is the number of blocks that must be built on top of
+	// a coinbase before its outputs can be spent
+	CoinbaseMaturity int
+
+	// Consensus selects the engine that seals and verifies blocks
+	Consensus ConsensusType
+	// Validators are the addresses of the keys that sign blocks in turn
+	// under proof-of-authority
+	Validators []string
+	// StakeMinAge is how many seconds an output must exist before it can be
+	// staked under proof-of-stake, StakeMaxAge caps the age it counts with
+	StakeMinAge int64
+	StakeMaxAge int64
+	// MinterPolicy limits who may produce blocks, anyone may when it is nil
+	MinterPolicy *MinterPolicy
+
+	// Checkpoints are known good blocks, ordered by height
+	Checkpoints []Checkpoint
+
+	// GenerateSupported allows blocks to be mined on demand with the
+	// generate command
+	GenerateSupported bool
+}
+
+// MainNetParams are the parameters of the main network
+var MainNetParams = ChainParams{
+	Name:       "mainnet",
+	DBFile:     "blockchain_%s.db",
+	WalletFile: "wallet_%s.dat",
+	PeerDBPath: "peers_%s",
+
+	AddressVersion:       0x01,
+	ScriptAddressVersion: 0x05,
+
+	NodeVersion: 1,
+	ProtocolID:  "/p2p/1.0.0",
+	Rendezvous:  "jy blockchain",
+
+	GenesisCoinbaseData: "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+
+	// The first 16 bits of the genesis block hash must be 0
+	GenesisBits:      0x1f010000,
+	PowLimitBits:     0x20010000,
+	PowLimit:         CompactToBig(0x20010000),
+	TargetSpacing:    10,
+	RetargetInterval: 20,
+	RetargetClamp:    4,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       10,
+}
+
+// TestNetParams are the parameters of the public test network. It follows
+// the rules of the main network, but its coins have no value.
+var TestNetParams = ChainParams{
+	Name:       "testnet",
+	DBFile:     "blockchain_testnet_%s.db",
+	WalletFile: "wallet_testnet_%s.dat",
+	PeerDBPath: "peers_testnet_%s",
+
+	AddressVersion:       0x6f,
+	ScriptAddressVersion: 0xc4,
+
+	NodeVersion: 1,
+	ProtocolID:  "/p2p-testnet/1.0.0",
+	Rendezvous:  "jy blockchain testnet",
+
+	GenesisCoinbaseData: "jy blockchain testnet genesis",
+
+	GenesisBits:      0x1f010000,
+	PowLimitBits:     0x20010000,
+	PowLimit:         CompactToBig(0x20010000),
+	TargetSpacing:    10,
+	RetargetInterval: 20,
+	RetargetClamp:    4,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       10,
+}
+
+// RegTestParams are the parameters of the regression test network, a local
+// network with trivial difficulty on which blocks are generated on demand
+var RegTestParams = ChainParams{
+	Name:       "regtest",
+	DBFile:     "blockchain_regtest_%s.db",
+	WalletFile: "wallet_regtest_%s.dat",
+	PeerDBPath: "peers_regtest_%s",
+
+	AddressVersion:       0x6f,
+	ScriptAddressVersion: 0xc4,
+
+	NodeVersion: 1,
+	ProtocolID:  "/p2p-regtest/1.0.0",
+	Rendezvous:  "jy blockchain regtest",
+
+	GenesisCoinbaseData: "jy blockchain regtest genesis",
+
+	GenesisBits:      0x207fffff,
+	PowLimitBits:     0x207fffff,
+	PowLimit:         CompactToBig(0x207fffff),
+	TargetSpacing:    10,
+	RetargetInterval: 20,
+	RetargetClamp:    4,
+	NoRetargeting:    true,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 150,
+	CoinbaseMaturity:       10,
+
+	GenerateSupported: true,
+}
+
+// StakeNetParams are the parameters of a test network secured by
+// proof-of-stake. The coins of the genesis block are staked right away, so
+// coinbases need no maturity; the rewards of staked blocks are paid by their
+// coinstake transactions.
+var StakeNetParams = ChainParams{
+	Name:       "stakenet",
+	DBFile:     "blockchain_stakenet_%s.db",
+	WalletFile: "wallet_stakenet_%s.dat",
+	PeerDBPath: "peers_stakenet_%s",
+
+	AddressVersion:       0x6f,
+	ScriptAddressVersion: 0xc4,
+
+	NodeVersion: 1,
+	ProtocolID:  "/p2p-stakenet/1.0.0",
+	Rendezvous:  "jy blockchain stakenet",
+
+	GenesisCoinbaseData: "jy blockchain stakenet genesis",
+
+	GenesisBits:      0x1f010000,
+	PowLimitBits:     0x20010000,
+	PowLimit:         CompactToBig(0x20010000),
+	TargetSpacing:    60,
+	RetargetInterval: 20,
+	RetargetClamp:    4,
+
+	InitialSubsidy:         10,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       0,
+
+	Consensus:   ConsensusPoS,
+	StakeMinAge: 60,
+	StakeMaxAge: 30 * 24 * 60 * 60,
+}
+
+// activeNetParams are the parameters of the network the node runs on
+var activeNetParams = &MainNetParams
+
+// ParamsForNetwork returns the parameters of the network with the given name
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams, &StakeNetParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown network %q", name)
+}
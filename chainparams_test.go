@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamsForNetwork(t *testing.T) {
+	params, err := ParamsForNetwork("testnet")
+	assert.Nil(t, err)
+	assert.Equal(t, &TestNetParams, params)
+
+	_, err = ParamsForNetwork("nonet")
+	assert.NotNil(t, err, "Unknown networks are rejected")
+}
+
+func TestAddressesAreBoundToNetwork(t *testing.T) {
+	defer func() { activeNetParams = &MainNetParams }()
+
+	wallet := NewWallet()
+	mainAddress := string(wallet.GetAddress())
+	assert.True(t, ValidateAddress(mainAddress))
+
+	activeNetParams = &TestNetParams
+	testAddress := string(wallet.GetAddress())
+	assert.NotEqual(t, mainAddress, testAddress)
+	assert.True(t, ValidateAddress(testAddress))
+	assert.False(t, ValidateAddress(mainAddress), "Mainnet addresses are invalid on testnet")
+
+	testScriptAddress := string(ScriptAddress([]byte{Op1}))
+	assert.True(t, ValidateAddress(testScriptAddress))
+
+	activeNetParams = &MainNetParams
+	assert.False(t, ValidateAddress(testAddress), "Testnet addresses are invalid on mainnet")
+	assert.False(t, ValidateAddress(testScriptAddress), "Testnet script addresses are invalid on mainnet")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// Checkpoint pins the hash of the block at a height. Blocks that conflict
+// with a checkpoint and branches forking off below the last checkpoint
+// reached are rejected.
+type Checkpoint struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// skipCheckpointedSignatures turns off signature verification for blocks
+// that checkpointed blocks build on, which speeds up the initial sync
+var skipCheckpointedSignatures = false
+
+// LoadCheckpoints reads a JSON list of checkpoints from a file
+func LoadCheckpoints(path string) ([]Checkpoint, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []Checkpoint
+	err = json.Unmarshal(content, &checkpoints)
+	if err != nil {
+		return nil, fmt.Errorf("parsing checkpoints %s: %s", path, err)
+	}
+
+	return checkpoints, nil
+}
+
+// AddCheckpoints merges checkpoints into the parameters. A checkpoint may
+// repeat an existing one, but not contradict it.
+func (params *ChainParams) AddCheckpoints(checkpoints []Checkpoint) error {
+	for _, checkpoint := range checkpoints {
+		hash, err := hex.DecodeString(checkpoint.Hash)
+		if err != nil || len(hash) != 32 || checkpoint.Height < 0 {
+			return fmt.Errorf("invalid checkpoint %d %s", checkpoint.Height, checkpoint.Hash)
+		}
+
+		existing := params.CheckpointAt(checkpoint.Height)
+		if existing == nil {
+			params.Checkpoints = append(params.Checkpoints, Checkpoint{checkpoint.Height, hex.EncodeToString(hash)})
+		} else if existing.Hash != hex.EncodeToString(hash) {
+			return fmt.Errorf("checkpoint %d %s conflicts with %s", checkpoint.Height, checkpoint.Hash, existing.Hash)
+		}
+	}
+
+	sort.Slice(params.Checkpoints, func(i, j int) bool {
+		return params.Checkpoints[i].Height < params.Checkpoints[j].Height
+	})
+
+	return nil
+}
+
+// CheckpointAt returns the checkpoint at the height, or nil if there is none
+func (params *ChainParams) CheckpointAt(height int) *Checkpoint {
+	for i := range params.Checkpoints {
+		if params.Checkpoints[i].Height == height {
+			return &params.Checkpoints[i]
+		}
+	}
+
+	return nil
+}
+
+// LastCheckpoint returns the highest checkpoint at or below maxHeight, or nil
+// if there is none
+func (params *ChainParams) LastCheckpoint(maxHeight int) *Checkpoint {
+	var last *Checkpoint
+
+	for i := range params.Checkpoints {
+		if params.Checkpoints[i].Height <= maxHeight {
+			last = &params.Checkpoints[i]
+		}
+	}
+
+	return last
+}
+
+// verifiesSignatures reports whether the signatures of the block have to be
+// checked. They are skipped only for the block of the next checkpoint and its
+// ancestors, once that block is stored; a block on a side branch below a
+// checkpoint is checked as usual.
+func verifiesSignatures(tx *bolt.Tx, block *Block) bool {
+	if !skipCheckpointedSignatures {
+		return true
+	}
+
+	var checkpoint *Checkpoint
+	for i := range activeNetParams.Checkpoints {
+		if activeNetParams.Checkpoints[i].Height >= block.Height {
+			checkpoint = &activeNetParams.Checkpoints[i]
+			break
+		}
+	}
+	if checkpoint == nil {
+		return true
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	hash, _ := hex.DecodeString(checkpoint.Hash)
+
+	for {
+		data := b.Get(hash)
+		if data == nil {
+			return true
+		}
+
+		ancestor := DeserializeBlock(data)
+		if ancestor.Height <= block.Height {
+			return !bytes.Equal(ancestor.Hash, block.Hash)
+		}
+		hash = ancestor.PrevBlockHash
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCheckpoints(t *testing.T) {
+	params := RegTestParams
+	params.Checkpoints = nil
+	hashA := strings.Repeat("ab", 32)
+	hashB := strings.Repeat("cd", 32)
+
+	err := params.AddCheckpoints([]Checkpoint{{20, hashB}, {10, hashA}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Checkpoint{{10, hashA}, {20, hashB}}, params.Checkpoints, "Checkpoints are sorted by height")
+
+	assert.Nil(t, params.AddCheckpoints([]Checkpoint{{10, strings.ToUpper(hashA)}}), "Repeating a checkpoint is allowed")
+	assert.NotNil(t, params.AddCheckpoints([]Checkpoint{{10, hashB}}), "Contradicting a checkpoint is not")
+	assert.NotNil(t, params.AddCheckpoints([]Checkpoint{{30, "abcd"}}), "Hashes must be 32 bytes")
+	assert.Len(t, params.Checkpoints, 2)
+
+	assert.Equal(t, hashB, params.CheckpointAt(20).Hash)
+	assert.Nil(t, params.CheckpointAt(15))
+	assert.Nil(t, params.LastCheckpoint(9))
+	assert.Equal(t, 10, params.LastCheckpoint(19).Height)
+	assert.Equal(t, 20, params.LastCheckpoint(100).Height)
+}
+
+func TestSkipCheckpointedSignaturesOnSideBranch(t *testing.T) {
+	params := MainNetParams
+	params.CoinbaseMaturity = 0
+	params.Checkpoints = []Checkpoint{{5, strings.Repeat("ab", 32)}}
+	activeNetParams = &params
+	skipCheckpointedSignatures = true
+	defer func() {
+		activeNetParams = &MainNetParams
+		skipCheckpointedSignatures = false
+	}()
+
+	alice, bob := NewWallet(), NewWallet()
+	bc := newTestBlockChain(t, string(alice.GetAddress()))
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	a1, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(alice.GetAddress()), "", 1, 0)})
+	assert.Nil(t, err)
+
+	// A side branch below the checkpoint spends the genesis coinbase with
+	// Bob's signature
+	coinbase := genesis.Transactions[0]
+	spend := &Transaction{nil, []TXInput{{Txid: coinbase.ID, Vout: 0}}, []TXOutput{*NewTXOutput(coinbase.Vout[0].Value, string(bob.GetAddress()))}, 0}
+	assert.Nil(t, bob.SignInput(spend, 0, coinbase.Vout[0], SigHashAll))
+	spend.ID = spend.Hash()
+
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(string(bob.GetAddress()), "", 1, 0)}, genesis.Hash, 1, params.GenesisBits, a1.Timestamp)
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := NewBlock([]*Transaction{NewCoinbaseTX(string(bob.GetAddress()), "", 2, 0), spend}, b1.Hash, 2, params.GenesisBits, a1.Timestamp+1)
+
+	err = bc.AddBlock(b2)
+	assert.Equal(t, RejectBadSignature, err.(RuleError).Code, "Blocks that no checkpoint builds on are verified")
+	assert.Equal(t, a1.Hash, bc.tip)
+}
@@ -16,15 +16,27 @@ type CLI struct {
 
 func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
+	fmt.Println("  Every command accepts -network NAME to run on mainnet (default), testnet, regtest or stakenet")
+	fmt.Println("  and -checkpoints FILE to add the checkpoints listed in the JSON file FILE")
+	fmt.Println("  and -validators FILE to switch to proof-of-authority with the validator addresses listed in the JSON file FILE")
+	fmt.Println("  and -minters FILE to accept only blocks signed by the minters of the JSON policy FILE")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  createblockchain -genesis SPEC - Create a blockchain with the genesis block described in the JSON file SPEC")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("  createmultisig -required M -pubkeys KEYS -alias NAME - Create an address spendable with M signatures of the comma separated hex public keys KEYS")
+	fmt.Println("  generate -count COUNT -address ADDRESS - Mine COUNT blocks rewarding ADDRESS right away (regtest only)")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  getsupply -height HEIGHT - Print the block subsidy and total coin supply at HEIGHT (default: best height)")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  mint -minter ADDRESS - Mint new block and get rewards")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -fee FEE -feerate RATE -mine - Send AMOUNT of coins from FROM address to TO, paying FEE or RATE per 1000 bytes to the miner. Mine on the same node, when -mine is set.")
+	fmt.Println("  spendmultisig -from FROM -to TO -amount AMOUNT -fee FEE -out FILE - Write an unsigned spend of AMOUNT from the multisig address FROM to TO into FILE")
+	fmt.Println("  signmultisig -in FILE -mine - Sign the multisig spend in FILE with the keys of this wallet and broadcast it once enough keyholders signed. Mine on the same node, when -mine is set.")
+	fmt.Println("  mint -minter ADDRESS - Mint new block and get rewards. On stakenet the coins of ADDRESS are staked")
+	fmt.Println("  poolworker -pool HOST:PORT -address ADDRESS - Mine shares for the pool at HOST:PORT, paid to ADDRESS")
+	fmt.Println("  miner -rpc URL -address ADDRESS - Mine blocks from the templates of the node at URL and send rewards to ADDRESS")
+	fmt.Println("  startnode -miner ADDRESS -rpcaddr ADDR - Start a node with ID specified in NODE_ID env. var. -miner enables mining, -rpcaddr serves block templates to miners")
 }
 
 func (cli *CLI) validateArgs() {
@@ -45,37 +57,91 @@ func (cli *CLI) Run(nodeID string) {
 	// 	os.Exit(1)
 	// }
 
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	getSupplyCmd := flag.NewFlagSet("getsupply", flag.ExitOnError)
 	createBlockChainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	createMultiSigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	spendMultiSigCmd := flag.NewFlagSet("spendmultisig", flag.ExitOnError)
+	signMultiSigCmd := flag.NewFlagSet("signmultisig", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	mintCmd := flag.NewFlagSet("mint", flag.ExitOnError)
+	minerCmd := flag.NewFlagSet("miner", flag.ExitOnError)
+	poolWorkerCmd := flag.NewFlagSet("poolworker", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	startP2PCmd := flag.NewFlagSet("startp2p", flag.ExitOnError)
 
+	var network, checkpointsFile, validatorsFile, mintersFile string
+	for _, cmd := range []*flag.FlagSet{generateCmd, getBalanceCmd, getSupplyCmd, createBlockChainCmd, createWalletCmd, createMultiSigCmd, spendMultiSigCmd, signMultiSigCmd, listAddressesCmd, printChainCmd, reindexUTXOCmd, sendCmd, mintCmd, minerCmd, poolWorkerCmd, startNodeCmd, startP2PCmd} {
+		cmd.StringVar(&network, "network", MainNetParams.Name, "The network to use: mainnet, testnet, regtest or stakenet")
+		cmd.StringVar(&checkpointsFile, "checkpoints", "", "JSON file with additional checkpoints")
+		cmd.StringVar(&validatorsFile, "validators", "", "JSON file with the addresses of the proof-of-authority validators, in signing order")
+		cmd.StringVar(&mintersFile, "minters", "", "JSON file with the public keys of the authorized minters and their rate limit")
+	}
+
+	generateCount := generateCmd.Int("count", 1, "Number of blocks to generate")
+	generateAddress := generateCmd.String("address", "", "The address to send the block rewards to")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	getSupplyHeight := getSupplyCmd.Int("height", -1, "The height to get the supply at")
 	createBlockChainAddress := createBlockChainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockChainGenesis := createBlockChainCmd.String("genesis", "", "JSON file describing the genesis block")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee paid to the miner per 1000 bytes of the transaction, overrides -fee")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
 	mintTo := mintCmd.String("minter", "", "Minter")
 	createWalletAlias := createWalletCmd.String("alias", "", "Name wallet")
+	createMultiSigRequired := createMultiSigCmd.Int("required", 0, "Number of signatures needed to spend")
+	createMultiSigPubKeys := createMultiSigCmd.String("pubkeys", "", "Comma separated hex public keys of the keyholders")
+	createMultiSigAlias := createMultiSigCmd.String("alias", "", "Name multisig address")
+	spendMultiSigFrom := spendMultiSigCmd.String("from", "", "Source multisig address")
+	spendMultiSigTo := spendMultiSigCmd.String("to", "", "Destination wallet address")
+	spendMultiSigAmount := spendMultiSigCmd.Int("amount", 0, "Amount to send")
+	spendMultiSigFee := spendMultiSigCmd.Int("fee", 0, "Fee paid to the miner")
+	spendMultiSigOut := spendMultiSigCmd.String("out", "", "File to write the unsigned spend to")
+	signMultiSigIn := signMultiSigCmd.String("in", "", "File with the multisig spend to sign")
+	signMultiSigMine := signMultiSigCmd.Bool("mine", false, "Mine immediately on the same node once the spend is fully signed")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	for _, cmd := range []*flag.FlagSet{generateCmd, sendCmd, mintCmd, minerCmd, poolWorkerCmd, startNodeCmd, startP2PCmd} {
+		cmd.IntVar(&miningThreads, "threads", miningThreads, "Number of goroutines to mine with")
+	}
+	startNodeCmd.StringVar(&rpcAddress, "rpcaddr", "", "Serve block templates to miners on this address, e.g. localhost:8332")
+	startP2PCmd.StringVar(&rpcAddress, "rpcaddr", "", "Serve block templates to miners on this address, e.g. localhost:8332")
+	startNodeCmd.StringVar(&poolAddress, "pooladdr", "", "Run a mining pool for TCP workers on this address, e.g. localhost:3333. Requires -miner")
+	startP2PCmd.StringVar(&poolAddress, "pooladdr", "", "Run a mining pool for TCP workers on this address, e.g. localhost:3333. Requires -minter")
+	poolWorkerPool := poolWorkerCmd.String("pool", "localhost:3333", "Address of the mining pool")
+	poolWorkerAddress := poolWorkerCmd.String("address", "", "The address to receive the pool payouts")
+	minerRPC := minerCmd.String("rpc", "http://localhost:8332", "URL of the node's JSON-RPC server")
+	minerAddress := minerCmd.String("address", "", "The address to send the block rewards to")
+	startNodeCmd.BoolVar(&skipCheckpointedSignatures, "skipcheckpointsigs", false, "Skip signature checks in blocks that checkpoints build on while syncing")
+	startP2PCmd.BoolVar(&skipCheckpointedSignatures, "skipcheckpointsigs", false, "Skip signature checks in blocks that checkpoints build on while syncing")
 	bootstrapPeersString := startP2PCmd.String("peer", "", "Adds a peer multiaddress to the bootstrap list")
-	rendezvous := startP2PCmd.String("rendezvous", "jy blockchain", "Unique string to identify group of nodes. Share this with your friends to let them connect with you")
+	rendezvous := startP2PCmd.String("rendezvous", "", "Unique string to identify group of nodes. Share this with your friends to let them connect with you (default: the network's rendezvous)")
 	secio := startP2PCmd.Bool("secio", false, "P2P network security I/O")
 	startP2PMinter := startP2PCmd.String("minter", "", "Enable minting mode and send reward to minter")
 
 	switch os.Args[1] {
+	case "generate":
+		err := generateCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getsupply":
+		err := getSupplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "createblockchain":
 		err := createBlockChainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -86,6 +152,21 @@ func (cli *CLI) Run(nodeID string) {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "createmultisig":
+		err := createMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "spendmultisig":
+		err := spendMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisig":
+		err := signMultiSigCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "listaddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -111,6 +192,16 @@ func (cli *CLI) Run(nodeID string) {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "miner":
+		err := minerCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "poolworker":
+		err := poolWorkerCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	// case "startnode":
 	// 	err := startNodeCmd.Parse(os.Args[2:])
 	// 	if err != nil {
@@ -126,6 +217,55 @@ func (cli *CLI) Run(nodeID string) {
 		os.Exit(1)
 	}
 
+	params, err := ParamsForNetwork(network)
+	if err != nil {
+		log.Panic(err)
+	}
+	activeNetParams = params
+
+	if checkpointsFile != "" {
+		checkpoints, err := LoadCheckpoints(checkpointsFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		err = activeNetParams.AddCheckpoints(checkpoints)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if validatorsFile != "" {
+		validators, err := LoadValidators(validatorsFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		activeNetParams.Consensus = ConsensusPoA
+		activeNetParams.Validators = validators
+	}
+
+	if mintersFile != "" {
+		policy, err := LoadMinterPolicy(mintersFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		activeNetParams.MinterPolicy = policy
+	}
+
+	cli.wallets = WalletsInstance(nodeID)
+
+	activeConsensus, err = NewConsensusEngine(activeNetParams, cli.wallets)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateAddress == "" || *generateCount <= 0 {
+			generateCmd.Usage()
+			os.Exit(1)
+		}
+		cli.generate(*generateCount, *generateAddress, nodeID)
+	}
+
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
@@ -134,18 +274,49 @@ func (cli *CLI) Run(nodeID string) {
 		cli.getBalance(*getBalanceAddress, nodeID)
 	}
 
+	if getSupplyCmd.Parsed() {
+		cli.getSupply(*getSupplyHeight, nodeID)
+	}
+
 	if createBlockChainCmd.Parsed() {
-		if *createBlockChainAddress == "" {
+		if *createBlockChainGenesis != "" {
+			cli.createBlockChainFromSpec(*createBlockChainGenesis, nodeID)
+		} else if *createBlockChainAddress == "" {
 			createBlockChainCmd.Usage()
 			os.Exit(1)
+		} else {
+			cli.createBlockChain(*createBlockChainAddress, nodeID)
 		}
-		cli.createBlockChain(*createBlockChainAddress, nodeID)
 	}
 
 	if createWalletCmd.Parsed() {
 		cli.createWallet(nodeID, *createWalletAlias)
 	}
 
+	if createMultiSigCmd.Parsed() {
+		if *createMultiSigRequired <= 0 || *createMultiSigPubKeys == "" {
+			createMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultiSig(*createMultiSigRequired, *createMultiSigPubKeys, *createMultiSigAlias, nodeID)
+	}
+
+	if spendMultiSigCmd.Parsed() {
+		if *spendMultiSigFrom == "" || *spendMultiSigTo == "" || *spendMultiSigAmount <= 0 || *spendMultiSigFee < 0 || *spendMultiSigOut == "" {
+			spendMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.spendMultiSig(*spendMultiSigFrom, *spendMultiSigTo, *spendMultiSigAmount, *spendMultiSigFee, *spendMultiSigOut, nodeID)
+	}
+
+	if signMultiSigCmd.Parsed() {
+		if *signMultiSigIn == "" {
+			signMultiSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMultiSig(*signMultiSigIn, nodeID, *signMultiSigMine)
+	}
+
 	if listAddressesCmd.Parsed() {
 		cli.listAddresses(nodeID)
 	}
@@ -159,12 +330,12 @@ func (cli *CLI) Run(nodeID string) {
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendFeeRate < 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendFeeRate, nodeID, *sendMine)
 	}
 
 	if mintCmd.Parsed() {
@@ -175,6 +346,22 @@ func (cli *CLI) Run(nodeID string) {
 		cli.mint(*mintTo, nodeId)
 	}
 
+	if minerCmd.Parsed() {
+		if *minerAddress == "" {
+			minerCmd.Usage()
+			os.Exit(1)
+		}
+		cli.runMiner(*minerRPC, *minerAddress)
+	}
+
+	if poolWorkerCmd.Parsed() {
+		if *poolWorkerAddress == "" {
+			poolWorkerCmd.Usage()
+			os.Exit(1)
+		}
+		cli.runPoolWorker(*poolWorkerPool, *poolWorkerAddress)
+	}
+
 	if startNodeCmd.Parsed() {
 		nodeID := os.Getenv("NODE_ID")
 		if nodeID == "" {
@@ -185,6 +372,9 @@ func (cli *CLI) Run(nodeID string) {
 	}
 
 	if startP2PCmd.Parsed() {
+		if *rendezvous == "" {
+			*rendezvous = activeNetParams.Rendezvous
+		}
 		cli.startP2P(nodeId, *startP2PMinter, *secio, 0, *rendezvous, *bootstrapPeersString)
 	}
 }
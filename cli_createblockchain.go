@@ -19,3 +19,24 @@ func (cli *CLI) createBlockChain(alias, nodeID string) {
 
 	fmt.Println("Done!")
 }
+
+func (cli *CLI) createBlockChainFromSpec(path, nodeID string) {
+	spec, err := LoadGenesisSpec(path)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	genesis, err := spec.Block()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("genesis: %x\n", genesis.Hash)
+
+	bc := CreateBlockChainWithGenesis(genesis, nodeID)
+	defer bc.db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	fmt.Println("Done!")
+}
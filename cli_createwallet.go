@@ -8,4 +8,5 @@ func (cli *CLI) createWallet(nodeID string, alias string) {
 	wallets.SaveToFile(nodeID)
 
 	fmt.Printf("Your new address: %s\n", address)
+	fmt.Printf("Public key: %x\n", wallets.Wallets[address].PublicKey)
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) generate(count int, alias, nodeID string) {
+	if !activeNetParams.GenerateSupported {
+		log.Panicf("ERROR: generate is not supported on %s", activeNetParams.Name)
+	}
+
+	address := cli.wallets.GetAddress(alias)
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+
+	for i := 0; i < count; i++ {
+		cbTx := NewCoinbaseTX(address, "", bc.GetBestHeight()+1, 0)
+		block, err := bc.MineBlock([]*Transaction{cbTx})
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("%x\n", block.Hash)
+	}
+}
@@ -14,9 +14,7 @@ func (cli *CLI) getBalance(address, nodeID string) {
 	defer bc.db.Close()
 
 	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	UTXOs := UTXOSet.FindScriptUTXO(NewTXOutput(0, address).LockingScript())
 
 	for _, out := range UTXOs {
 		balance += out.Value
@@ -0,0 +1,14 @@
+package main
+
+import "fmt"
+
+func (cli *CLI) getSupply(height int, nodeID string) {
+	if height < 0 {
+		bc := NewBlockChain(nodeID)
+		height = bc.GetBestHeight()
+		bc.db.Close()
+	}
+
+	fmt.Printf("Block subsidy at height %d: %d\n", height, GetBlockSubsidy(height))
+	fmt.Printf("Total supply at height %d: %d\n", height, TotalSupply(height))
+}
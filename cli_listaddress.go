@@ -12,6 +12,11 @@ func (cli *CLI) listAddresses(nodeID string) {
 	addresses := wallets.GetAllAliases()
 
 	for _, alias := range addresses {
-		fmt.Println(wallets.GetAddress(alias), " alias: ", alias)
+		address := wallets.GetAddress(alias)
+		if multiSig := wallets.GetMultiSig(address); multiSig != nil {
+			fmt.Println(address, " alias: ", alias, " multisig: ", fmt.Sprintf("%d of %d", multiSig.Required, len(multiSig.PubKeys)))
+			continue
+		}
+		fmt.Println(address, " alias: ", alias, " pubkey: ", fmt.Sprintf("%x", wallets.Wallets[address].PublicKey))
 	}
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// templateRefreshInterval is how often the external miner checks whether
+// the chain moved on from the block it is working on
+const templateRefreshInterval = 5 * time.Second
+
+// 作为独立的矿工进程运行：通过{rpcURL}从节点获取区块模板，
+// 求解后用submitblock提交，奖励发送到{alias}
+func (cli *CLI) runMiner(rpcURL, alias string) {
+	address := cli.wallets.GetAddress(alias)
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	for {
+		var template BlockTemplate
+		err := rpcCall(rpcURL, "getblocktemplate", nil, &template)
+		if err != nil {
+			log.Println(err)
+			time.Sleep(templateRefreshInterval)
+			continue
+		}
+
+		coinbase := NewCoinbaseTXWithValue(address, "", template.CoinbaseValue)
+		block, err := template.NewBlock(coinbase)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go watchTemplate(ctx, cancel, rpcURL, template.PrevBlockHash)
+
+		nonce, hash, err := NewProofOfWork(block).RunParallel(ctx, miningThreads)
+		cancel()
+		if err != nil {
+			fmt.Println("Chain tip changed, fetching a new block template")
+			continue
+		}
+		block.Nonce = nonce
+		block.Hash = hash
+
+		err = rpcCall(rpcURL, "submitblock", []interface{}{hex.EncodeToString(block.Serialize())}, nil)
+		if err != nil {
+			fmt.Printf("Block %x was rejected: %s\n", block.Hash, err)
+			continue
+		}
+		fmt.Printf("Block %x was accepted\n", block.Hash)
+	}
+}
+
+// watchTemplate cancels the mining context once the node's tip is no longer
+// prevHash
+func watchTemplate(ctx context.Context, cancel context.CancelFunc, rpcURL, prevHash string) {
+	ticker := time.NewTicker(templateRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var template BlockTemplate
+			err := rpcCall(rpcURL, "getblocktemplate", nil, &template)
+			if err == nil && template.PrevBlockHash != prevHash {
+				cancel()
+				return
+			}
+		}
+	}
+}
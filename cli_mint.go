@@ -13,13 +13,14 @@ func (cli *CLI) mint(to string, nodeId string) {
 	}
 
 	chain := NewBlockChain(nodeId)
-	UTXOSet := UTXOSet{BlockChain: chain}
 	defer chain.db.Close()
 
-	cbTx := NewCoinbaseTX(to, "")
+	cbTx := NewCoinbaseTX(to, "", chain.GetBestHeight()+1, 0)
 	txs := []*Transaction{cbTx}
-	block := chain.MineBlock(txs)
-	UTXOSet.Update(block)
+	_, err := chain.MineBlock(txs)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Println("Success!")
 
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 从公钥{pubKeys}（逗号分隔的十六进制）创建需要{required}个签名的多签地址
+func (cli *CLI) createMultiSig(required int, pubKeys, alias, nodeID string) {
+	var keys [][]byte
+	for _, pubKey := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(strings.TrimSpace(pubKey))
+		if err != nil || len(key) == 0 {
+			log.Panicf("ERROR: Public key %q is not valid", pubKey)
+		}
+		keys = append(keys, key)
+	}
+
+	wallets := cli.wallets
+	address, err := wallets.AddMultiSig(required, keys, alias)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Your new multisig address: %s\n", address)
+	fmt.Printf("Redeem script: %s\n", DisasmScript(wallets.MultiSig[address].RedeemScript))
+}
+
+// 从多签地址{from}发送{amount}到{to}，手续费为{fee}
+// 未签名的交易写入文件{out}，由各持钥人用signmultisig签名
+func (cli *CLI) spendMultiSig(from, to string, amount, fee int, out, nodeID string) {
+	if !IsScriptAddress(from) {
+		log.Panic("ERROR: Sender address is not a multisig address")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+
+	wallet := cli.wallets.GetMultiSig(from)
+	if wallet == nil {
+		log.Panic("ERROR: Sender address is not a multisig address of this wallet, create it with createmultisig first")
+	}
+
+	bc := NewBlockChain(nodeID)
+	UTXOSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	spend, err := NewMultiSigTransaction(wallet, to, amount, fee, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+	spend.SaveToFile(out)
+
+	fmt.Println(spend.Tx)
+	fmt.Printf("Unsigned spend written to %s, it needs %d signatures\n", out, spend.Required())
+}
+
+// 用本节点的密钥为文件{in}中的多签交易签名
+// 签名数达到要求后广播交易，如果{mineNow}为true，则在本节点打包
+func (cli *CLI) signMultiSig(in, nodeID string, mineNow bool) {
+	spend, err := LoadMultiSigSpend(in)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(spend.Tx)
+
+	_, pubKeys, _ := extractMultiSig(spend.RedeemScript)
+	signed := 0
+	for _, pubKey := range pubKeys {
+		for address, wallet := range cli.wallets.Wallets {
+			if !bytes.Equal(wallet.PublicKey, pubKey) {
+				continue
+			}
+
+			err := spend.Sign(wallet)
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Printf("Signed with the key of %s\n", address)
+			signed++
+		}
+	}
+	if signed == 0 {
+		log.Panicf("ERROR: No key of %s is in the wallet", spend.Address())
+	}
+
+	spend.SaveToFile(in)
+	fmt.Printf("%d of %d required signatures collected\n", spend.Signed(), spend.Required())
+
+	if spend.Signed() < spend.Required() {
+		fmt.Printf("Pass %s on to the next keyholder\n", in)
+		return
+	}
+
+	tx, err := spend.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if mineNow {
+		bc := NewBlockChain(nodeID)
+		UTXOSet := UTXOSet{bc}
+		defer bc.db.Close()
+
+		fee, err := UTXOSet.CalcFee(tx)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		cbTx := NewCoinbaseTX(spend.Address(), "", bc.GetBestHeight()+1, fee)
+		txs := []*Transaction{cbTx, tx}
+
+		_, err = bc.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		sendTxOnce(knownNodes[0], tx)
+		fmt.Println("send tx")
+	}
+
+	fmt.Println("Success!")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// 作为矿池的工作节点运行：连接{poolAddr}的矿池，按矿池下发的任务
+// 寻找满足份额难度的区块头并提交，奖励按贡献支付到{alias}
+func (cli *CLI) runPoolWorker(poolAddr, alias string) {
+	address := cli.wallets.GetAddress(alias)
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	conn, err := net.Dial(protocol, poolAddr)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer conn.Close()
+
+	var sendMu sync.Mutex
+	requestID := 0
+	send := func(method string, params ...interface{}) {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+
+		requestID++
+		data, err := json.Marshal(map[string]interface{}{"id": requestID, "method": method, "params": params})
+		if err != nil {
+			log.Panic(err)
+		}
+		_, err = conn.Write(append(data, '\n'))
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	jobs := make(chan *PoolJob, 1)
+	var extraNonce1 []byte
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	send("subscribe")
+	if !scanner.Scan() {
+		log.Panic("ERROR: The pool closed the connection")
+	}
+	var subscribed struct {
+		Result poolSubscription `json:"result"`
+	}
+	err = json.Unmarshal(scanner.Bytes(), &subscribed)
+	if err != nil {
+		log.Panic(err)
+	}
+	extraNonce1, err = hex.DecodeString(subscribed.Result.ExtraNonce1)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	send("authorize", address)
+
+	go func() {
+		for scanner.Scan() {
+			var message struct {
+				Method string            `json:"method"`
+				Params []json.RawMessage `json:"params"`
+				Result interface{}       `json:"result"`
+				Error  *string           `json:"error"`
+			}
+			err := json.Unmarshal(scanner.Bytes(), &message)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			switch {
+			case message.Method == "notify":
+				var job PoolJob
+				err := json.Unmarshal(message.Params[0], &job)
+				if err != nil {
+					log.Panic(err)
+				}
+
+				select {
+				case <-jobs:
+				default:
+				}
+				jobs <- &job
+			case message.Error != nil:
+				fmt.Printf("Pool rejected: %s\n", *message.Error)
+			default:
+				fmt.Println("Pool accepted")
+			}
+		}
+
+		log.Panic("ERROR: The pool closed the connection")
+	}()
+
+	job := <-jobs
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		next := make(chan *PoolJob, 1)
+		go func() {
+			newJob := <-jobs
+			cancel()
+			next <- newJob
+		}()
+
+		mineJob(ctx, job, extraNonce1, func(extraNonce2 []byte, timestamp int64, nonce uint32) {
+			send("submit", job.ID, hex.EncodeToString(extraNonce2), timestamp, nonce)
+		})
+
+		job = <-next
+	}
+}
+
+// mineJob searches for shares of the job, moving on to the next extra nonce
+// after every share, until the context is cancelled
+func mineJob(ctx context.Context, job *PoolJob, extraNonce1 []byte, submit func([]byte, int64, uint32)) {
+	shareBits := mustParseBits(job.ShareBits)
+	extraNonce2 := make([]byte, extraNonce2Size)
+
+	for i := uint32(0); ; i++ {
+		binary.BigEndian.PutUint32(extraNonce2, i)
+
+		block, err := job.Block(extraNonce1, extraNonce2)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		pow := &ProofOfWork{block, CompactToBig(shareBits)}
+		nonce, _, err := pow.RunParallel(ctx, miningThreads)
+		if err != nil {
+			return
+		}
+
+		submit(extraNonce2, block.Timestamp, nonce)
+	}
+}
@@ -17,8 +17,12 @@ func (cli *CLI) printChain(nodeID string) {
 		fmt.Printf("============ Block %x ============\n", block.Hash)
 		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("Bits: %08x\n", block.Bits)
+		if len(block.Signer) > 0 {
+			fmt.Printf("Signer: %x\n", block.Signer)
+		}
+		valid := block.Bits == bc.GetRequiredBits(block.PrevBlockHash) && activeConsensus.VerifySeal(block) == nil
+		fmt.Printf("%s: %s\n\n", activeConsensus.Name(), strconv.FormatBool(valid))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
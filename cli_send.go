@@ -5,10 +5,17 @@ import (
 	"log"
 )
 
-func (cli *CLI) sendNet(from, to string, amount int, nodeID string, mineNow bool) {
+// 从{from}发送{to}到{amount}
+// 手续费为{fee}，或者按{feeRate}（每1000字节）计算
+// 如果{mintNow}为true，则创建包含send事务的块
+// 如果{mintNow}为false，则创建事务并将其发送给中央节点（targetPeer）
+func (cli *CLI) send(from, to string, amount, fee, feeRate int, nodeID string, mineNow bool) {
 	if !ValidateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
+	if IsScriptAddress(from) {
+		log.Panic("ERROR: Sender address is a script address, which has no key in the wallet")
+	}
 	if !ValidateAddress(to) {
 		log.Panic("ERROR: Recipient address is not valid")
 	}
@@ -17,52 +24,29 @@ func (cli *CLI) sendNet(from, to string, amount int, nodeID string, mineNow bool
 	UTXOSet := UTXOSet{bc}
 	defer bc.db.Close()
 
-	// wallets, err := NewWallets(nodeID)
-	// if err != nil {
-	// 	log.Panic(err)
-	// }
 	wallet := cli.wallets.GetWallet(from)
 
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
-
-	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
-		txs := []*Transaction{cbTx, tx}
-
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+	var tx *Transaction
+	if feeRate > 0 {
+		tx = NewUTXOTransactionWithFeeRate(&wallet, to, amount, feeRate, &UTXOSet)
 	} else {
-		sendTx(knownNodes[0], tx)
+		tx = NewUTXOTransaction(&wallet, to, amount, fee, &UTXOSet)
 	}
 
-	fmt.Println("Success!")
-}
-
-// 从{from}发送{to}到{amount}
-// 如果{mintNow}为true，则创建包含send事务的块
-// 如果{mintNow}为false，则创建事务并将其发送给中央节点（targetPeer）
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
-	if !ValidateAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+	fee, err := UTXOSet.CalcFee(tx)
+	if err != nil {
+		log.Panic(err)
 	}
-	if !ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
-	}
-
-	bc := NewBlockChain(nodeID)
-	UTXOSet := UTXOSet{bc}
-	defer bc.db.Close()
-
-	wallet := cli.wallets.GetWallet(from)
-
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	fmt.Printf("Transaction fee: %d\n", fee)
 
 	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
+		cbTx := NewCoinbaseTX(from, "", bc.GetBestHeight()+1, fee)
 		txs := []*Transaction{cbTx, tx}
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+		_, err = bc.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
 		sendTxOnce(knownNodes[0], tx)
 		fmt.Println("send tx")
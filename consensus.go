@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// ConsensusType selects the engine that decides who may produce blocks
+type ConsensusType int
+
+const (
+	// ConsensusPoW lets anyone produce a block by solving a proof-of-work
+	ConsensusPoW ConsensusType = iota
+	// ConsensusPoA lets the validators of the chain params sign blocks in
+	// round-robin order
+	ConsensusPoA
+	// ConsensusPoS lets the owners of old enough outputs mint blocks with a
+	// chance that grows with the value and age of their coins
+	ConsensusPoS
+)
+
+// ConsensusEngine seals new blocks, verifies the seals of received blocks and
+// sets the difficulty and the weight of blocks for fork choice
+type ConsensusEngine interface {
+	// Name identifies the engine in the output of the node
+	Name() string
+	// RequiredBits returns the bits of a block built on top of parent
+	RequiredBits(tx *bolt.Tx, parent *Block) uint32
+	// Seal completes the block built on top of the tip of bc and sets its
+	// hash. bc is nil for the genesis block. threads bounds the goroutines
+	// the engine may use. Sealing stops with the context's error when the
+	// context is cancelled.
+	Seal(ctx context.Context, bc *BlockChain, block *Block, threads int) error
+	// VerifySeal checks the seal of the block without looking at the chain
+	VerifySeal(block *Block) error
+	// VerifyState checks the seal of the block against the chain it
+	// extends: the blocks before it and the UTXO set, which is at the state
+	// of the block's parent
+	VerifyState(tx *bolt.Tx, block *Block) error
+	// Work returns what the block adds to the cumulative work of its chain
+	Work(block *Block) *big.Int
+}
+
+// activeConsensus is the consensus engine of the active network
+var activeConsensus ConsensusEngine = ProofOfWorkEngine{}
+
+// NewConsensusEngine creates the engine the chain params ask for, limited to
+// the authorized minters when the params have a minter policy. Engines that
+// sign blocks take their keys from wallets.
+func NewConsensusEngine(params *ChainParams, wallets *Wallets) (ConsensusEngine, error) {
+	var engine ConsensusEngine
+	var err error
+
+	switch params.Consensus {
+	case ConsensusPoW:
+		engine = ProofOfWorkEngine{}
+	case ConsensusPoA:
+		engine, err = NewProofOfAuthority(params.Validators, wallets)
+	case ConsensusPoS:
+		engine = &ProofOfStake{wallets}
+	default:
+		err = fmt.Errorf("unknown consensus type %d", params.Consensus)
+	}
+	if err != nil || params.MinterPolicy == nil {
+		return engine, err
+	}
+
+	return NewAuthorizedMinting(engine, params.MinterPolicy, wallets)
+}
+
+// isProofOfWork reports whether the engine seals blocks with proof-of-work,
+// which external miners can take part in. An AuthorizedMinting is judged by
+// the engine it wraps.
+func isProofOfWork(engine ConsensusEngine) bool {
+	if m, ok := engine.(*AuthorizedMinting); ok {
+		engine = m.ConsensusEngine
+	}
+
+	_, ok := engine.(ProofOfWorkEngine)
+	return ok
+}
+
+// ProofOfWorkEngine seals blocks by finding a nonce that hashes the header
+// below the target of its bits
+type ProofOfWorkEngine struct{}
+
+// Name returns the name of the engine
+func (ProofOfWorkEngine) Name() string {
+	return "PoW"
+}
+
+// RequiredBits retargets every RetargetInterval blocks
+func (ProofOfWorkEngine) RequiredBits(tx *bolt.Tx, parent *Block) uint32 {
+	return nextRequiredBits(tx, parent)
+}
+
+// Seal mines the block
+func (ProofOfWorkEngine) Seal(ctx context.Context, bc *BlockChain, block *Block, threads int) error {
+	nonce, hash, err := NewProofOfWork(block).RunParallel(ctx, threads)
+	if err != nil {
+		return err
+	}
+
+	block.Nonce = nonce
+	block.Hash = hash
+
+	return nil
+}
+
+// VerifySeal checks that the block's hash meets its target
+func (ProofOfWorkEngine) VerifySeal(block *Block) error {
+	if !NewProofOfWork(block).Validate(block.Bits) {
+		return ruleError(RejectBadPoW, "block %x does not meet its target %08x", block.Hash, block.Bits)
+	}
+
+	return nil
+}
+
+// VerifyState has nothing to check, the seal does not depend on the UTXO set
+func (ProofOfWorkEngine) VerifyState(tx *bolt.Tx, block *Block) error {
+	return nil
+}
+
+// Work returns the expected number of hashes needed to mine the block
+func (ProofOfWorkEngine) Work(block *Block) *big.Int {
+	return NewProofOfWork(block).Work()
+}
+
+// blockSignatureLen is the length of a block signature: r and s, each padded
+// to 32 bytes
+const blockSignatureLen = 64
+
+// signBlock signs the hash of the block with the wallet's key
+func signBlock(block *Block, wallet *Wallet) error {
+	r, s, err := ecdsa.Sign(rand.Reader, &wallet.PrivateKey, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	signature := make([]byte, blockSignatureLen)
+	r.FillBytes(signature[:blockSignatureLen/2])
+	s.FillBytes(signature[blockSignatureLen/2:])
+
+	block.Signer = wallet.PublicKey
+	block.Signature = signature
+
+	return nil
+}
+
+// verifyBlockSignature checks that Signer signed the hash of the block
+func verifyBlockSignature(block *Block) bool {
+	return verifySignature(block.Signer, block.Hash, block.Signature)
+}
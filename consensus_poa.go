@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// ProofOfAuthority seals blocks with the keys of a fixed set of validators.
+// The validator at index height % len(validators) signs the block at that
+// height; blocks signed by anyone else are rejected. The genesis block is
+// not signed.
+type ProofOfAuthority struct {
+	validators   []string
+	pubKeyHashes [][]byte
+	wallets      *Wallets
+}
+
+// NewProofOfAuthority creates an engine for the validator addresses, in
+// signing order. Blocks can only be sealed for validators whose keys are in
+// wallets, which may be nil on nodes that only verify.
+func NewProofOfAuthority(validators []string, wallets *Wallets) (*ProofOfAuthority, error) {
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("proof-of-authority needs at least one validator")
+	}
+
+	engine := &ProofOfAuthority{wallets: wallets}
+	for _, address := range validators {
+		version, pubKeyHash, ok := decodeAddress(address)
+		if !ok || version != activeNetParams.AddressVersion {
+			return nil, fmt.Errorf("validator address %s is not a valid pubkey hash address", address)
+		}
+
+		engine.validators = append(engine.validators, address)
+		engine.pubKeyHashes = append(engine.pubKeyHashes, pubKeyHash)
+	}
+
+	return engine, nil
+}
+
+// LoadValidators reads a JSON list of validator addresses from a file
+func LoadValidators(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var validators []string
+	err = json.Unmarshal(content, &validators)
+	if err != nil {
+		return nil, fmt.Errorf("parsing validators %s: %s", path, err)
+	}
+
+	return validators, nil
+}
+
+// Name returns the name of the engine
+func (poa *ProofOfAuthority) Name() string {
+	return "PoA"
+}
+
+// RequiredBits keeps the bits of the genesis block, they carry no meaning
+func (poa *ProofOfAuthority) RequiredBits(tx *bolt.Tx, parent *Block) uint32 {
+	return parent.Bits
+}
+
+// Signer returns the address of the validator that signs the block at the
+// height
+func (poa *ProofOfAuthority) Signer(height int) string {
+	return poa.validators[height%len(poa.validators)]
+}
+
+// Seal signs the block with the key of the validator in turn
+func (poa *ProofOfAuthority) Seal(ctx context.Context, bc *BlockChain, block *Block, threads int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	block.Hash = block.BlockHeader.Hash()
+	if block.Height == 0 {
+		return nil
+	}
+
+	signer := poa.Signer(block.Height)
+	if poa.wallets == nil || poa.wallets.Wallets[signer] == nil {
+		return fmt.Errorf("block %d must be signed by validator %s, whose key is not in the wallet", block.Height, signer)
+	}
+
+	return signBlock(block, poa.wallets.Wallets[signer])
+}
+
+// VerifySeal checks that the validator in turn signed the block
+func (poa *ProofOfAuthority) VerifySeal(block *Block) error {
+	if block.Height == 0 {
+		return nil
+	}
+
+	expected := poa.pubKeyHashes[block.Height%len(poa.pubKeyHashes)]
+	if len(block.Signer) == 0 || !bytes.Equal(HashPubKey(block.Signer), expected) {
+		return ruleError(RejectBadSigner, "block %x at height %d is not signed by validator %s", block.Hash, block.Height, poa.Signer(block.Height))
+	}
+
+	if !verifyBlockSignature(block) {
+		return ruleError(RejectBadSigner, "signature of block %x is not valid", block.Hash)
+	}
+
+	return nil
+}
+
+// VerifyState has nothing to check, the seal does not depend on the UTXO set
+func (poa *ProofOfAuthority) VerifyState(tx *bolt.Tx, block *Block) error {
+	return nil
+}
+
+// Work counts every block the same, so the longest chain wins
+func (poa *ProofOfAuthority) Work(block *Block) *big.Int {
+	return big.NewInt(1)
+}
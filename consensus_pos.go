@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// stakeKernelLen is the size of the data hashed into a stake kernel:
+// prev block hash(32) | stake txid(32) | stake vout(4) | stake time(8) | timestamp(8)
+const stakeKernelLen = 84
+
+// ProofOfStake lets the owner of an unspent output mint a block. The output
+// qualifies at a timestamp when the hash of its kernel is below the target
+// of the block's bits multiplied by the output's value and age. The block's
+// coinstake, its second transaction, spends the output and returns it
+// together with the reward, and the owner signs the block.
+type ProofOfStake struct {
+	wallets *Wallets
+}
+
+// Name returns the name of the engine
+func (pos *ProofOfStake) Name() string {
+	return "PoS"
+}
+
+// RequiredBits retargets every RetargetInterval blocks, like proof-of-work
+func (pos *ProofOfStake) RequiredBits(tx *bolt.Tx, parent *Block) uint32 {
+	return nextRequiredBits(tx, parent)
+}
+
+// Seal stakes the coins of the address the coinbase pays to. Once one of
+// its outputs qualifies, the reward moves from the coinbase to a coinstake
+// that spends the output. The search goes on second by second until the
+// context is cancelled.
+func (pos *ProofOfStake) Seal(ctx context.Context, bc *BlockChain, block *Block, threads int) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if block.Height == 0 {
+		block.Hash = block.BlockHeader.Hash()
+		return nil
+	}
+
+	pubKeyHash := block.Transactions[0].Vout[0].PubKeyHash
+	wallet := pos.findWallet(pubKeyHash)
+	if wallet == nil {
+		return fmt.Errorf("the key of minter %x is not in the wallet", pubKeyHash)
+	}
+
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions[1:] {
+		for _, vin := range tx.Vin {
+			spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+	}
+
+	var stakes []Stake
+	for _, stake := range (UTXOSet{bc}).FindStakes(pubKeyHash) {
+		if !spent[fmt.Sprintf("%x:%d", stake.Txid, stake.Vout)] {
+			stakes = append(stakes, stake)
+		}
+	}
+	if len(stakes) == 0 {
+		return fmt.Errorf("minter %x has no coins to stake", pubKeyHash)
+	}
+
+	fmt.Printf("Staking %d outputs for block %d\n", len(stakes), block.Height)
+	target := CompactToBig(block.Bits)
+	minTimestamp := block.Timestamp
+	tried := int64(0)
+	for {
+		timestamp := timeSource.AdjustedTime()
+		if timestamp < minTimestamp {
+			timestamp = minTimestamp
+		}
+
+		if timestamp > tried {
+			for _, stake := range stakes {
+				if checkStakeKernel(block.PrevBlockHash, stake, timestamp, target) {
+					fmt.Printf("Found stake %x:%d for block %d\n\n", stake.Txid, stake.Vout, block.Height)
+					return mintBlock(bc, block, wallet, stake, timestamp)
+				}
+			}
+			tried = timestamp
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// findWallet returns the wallet of the public key hash, or nil if there is none
+func (pos *ProofOfStake) findWallet(pubKeyHash []byte) *Wallet {
+	if pos.wallets == nil {
+		return nil
+	}
+
+	for _, wallet := range pos.wallets.Wallets {
+		if bytes.Equal(HashPubKey(wallet.PublicKey), pubKeyHash) {
+			return wallet
+		}
+	}
+
+	return nil
+}
+
+// mintBlock completes the block with a coinstake spending the stake and
+// signs it
+func mintBlock(bc *BlockChain, block *Block, wallet *Wallet, stake Stake, timestamp int64) error {
+	coinbase := block.Transactions[0]
+	reward := 0
+	for _, out := range coinbase.Vout {
+		reward += out.Value
+	}
+
+	pubKeyHash := HashPubKey(wallet.PublicKey)
+	input := TXInput{Txid: stake.Txid, Vout: stake.Vout, PubKey: wallet.PublicKey}
+	output := TXOutput{Value: stake.Output.Value + reward, PubKeyHash: pubKeyHash}
+	coinstake := Transaction{nil, []TXInput{input}, []TXOutput{output}, 0}
+	coinstake.ID = coinstake.Hash()
+	err := bc.SignTransaction(&coinstake, wallet.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	emptied := Transaction{nil, coinbase.Vin, []TXOutput{{Value: 0, PubKeyHash: pubKeyHash}}, 0}
+	emptied.ID = emptied.Hash()
+
+	block.Transactions = append([]*Transaction{&emptied, &coinstake}, block.Transactions[1:]...)
+	block.Timestamp = timestamp
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+
+	return signBlock(block, wallet)
+}
+
+// VerifySeal checks that the block has a coinstake and is signed by the key
+// that spends the stake
+func (pos *ProofOfStake) VerifySeal(block *Block) error {
+	if block.Height == 0 {
+		return nil
+	}
+
+	coinstake := block.Coinstake()
+	if coinstake == nil || coinstake.IsCoinbase() || len(coinstake.Vin) == 0 {
+		return ruleError(RejectBadStake, "block %x has no coinstake", block.Hash)
+	}
+
+	if !bytes.Equal(coinstake.Vin[0].PubKey, block.Signer) {
+		return ruleError(RejectBadStake, "block %x is not signed by its staker", block.Hash)
+	}
+
+	if !verifyBlockSignature(block) {
+		return ruleError(RejectBadSigner, "signature of block %x is not valid", block.Hash)
+	}
+
+	return nil
+}
+
+// VerifyState checks the kernel of the output the coinstake stakes
+func (pos *ProofOfStake) VerifyState(tx *bolt.Tx, block *Block) error {
+	if block.Height == 0 {
+		return nil
+	}
+
+	kernel := block.Coinstake().Vin[0]
+	outsData := tx.Bucket([]byte(utxoBucket)).Get(kernel.Txid)
+	if outsData == nil {
+		return ruleError(RejectBadStake, "block %x stakes %x:%d, which is not unspent", block.Hash, kernel.Txid, kernel.Vout)
+	}
+
+	outs := DeserializeOutputs(outsData)
+	out, ok := outs.Outputs[kernel.Vout]
+	if !ok {
+		return ruleError(RejectBadStake, "block %x stakes %x:%d, which is not unspent", block.Hash, kernel.Txid, kernel.Vout)
+	}
+
+	stake := Stake{kernel.Txid, kernel.Vout, out, outs.Timestamp}
+	if !checkStakeKernel(block.PrevBlockHash, stake, block.Timestamp, CompactToBig(block.Bits)) {
+		return ruleError(RejectBadStake, "kernel of block %x does not meet its target %08x", block.Hash, block.Bits)
+	}
+
+	return nil
+}
+
+// Work returns the expected number of kernel hashes needed to find the block
+func (pos *ProofOfStake) Work(block *Block) *big.Int {
+	return NewProofOfWork(block).Work()
+}
+
+// stakeWeight returns the value of the stake multiplied by its age in
+// seconds at the timestamp. Stakes younger than StakeMinAge weigh nothing,
+// and the age counts up to StakeMaxAge.
+func stakeWeight(stake Stake, timestamp int64) *big.Int {
+	age := timestamp - stake.Timestamp
+	if age < activeNetParams.StakeMinAge {
+		return big.NewInt(0)
+	}
+	if age > activeNetParams.StakeMaxAge {
+		age = activeNetParams.StakeMaxAge
+	}
+
+	return new(big.Int).Mul(big.NewInt(int64(stake.Output.Value)), big.NewInt(age))
+}
+
+// stakeKernelHash hashes the stake together with the block it would mint
+func stakeKernelHash(prevBlockHash []byte, stake Stake, timestamp int64) []byte {
+	data := make([]byte, stakeKernelLen)
+
+	copy(data[0:32], prevBlockHash)
+	copy(data[32:64], stake.Txid)
+	binary.BigEndian.PutUint32(data[64:68], uint32(stake.Vout))
+	binary.BigEndian.PutUint64(data[68:76], uint64(stake.Timestamp))
+	binary.BigEndian.PutUint64(data[76:84], uint64(timestamp))
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// checkStakeKernel reports whether the stake may mint the block on top of
+// prevBlockHash at the timestamp
+func checkStakeKernel(prevBlockHash []byte, stake Stake, timestamp int64, target *big.Int) bool {
+	weight := stakeWeight(stake, timestamp)
+	if weight.Sign() == 0 {
+		return false
+	}
+
+	hashInt := new(big.Int).SetBytes(stakeKernelHash(prevBlockHash, stake, timestamp))
+
+	return hashInt.Cmp(new(big.Int).Mul(target, weight)) < 0
+}
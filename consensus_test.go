@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProofOfAuthority(t *testing.T) {
+	first, second := NewWallet(), NewWallet()
+	firstAddress, secondAddress := string(first.GetAddress()), string(second.GetAddress())
+	wallets := &Wallets{
+		Wallets: map[string]*Wallet{firstAddress: first, secondAddress: second},
+		Alias:   map[string]string{},
+	}
+
+	poa, err := NewProofOfAuthority([]string{firstAddress, secondAddress}, wallets)
+	assert.Nil(t, err)
+	verifier, err := NewProofOfAuthority([]string{firstAddress, secondAddress}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, secondAddress, poa.Signer(1))
+	assert.Equal(t, firstAddress, poa.Signer(2))
+
+	coinbase := NewCoinbaseTX(firstAddress, "", 1, 0)
+	block := newBlockTemplate([]*Transaction{coinbase}, make([]byte, 32), 1, activeNetParams.GenesisBits, time.Now().Unix())
+	assert.Nil(t, poa.Seal(context.Background(), nil, block, 1))
+	assert.Equal(t, second.PublicKey, block.Signer, "The validator in turn signs")
+	assert.Nil(t, verifier.VerifySeal(block))
+
+	block.Height = 2
+	err = verifier.VerifySeal(block)
+	assert.Equal(t, RejectBadSigner, err.(RuleError).Code, "Validators sign only in their turn")
+	block.Height = 1
+
+	block.Signature[0] ^= 0xff
+	assert.NotNil(t, verifier.VerifySeal(block), "Tampered signatures are rejected")
+
+	_, err = NewProofOfAuthority(nil, nil)
+	assert.NotNil(t, err)
+	assert.NotNil(t, verifier.Seal(context.Background(), nil, block, 1), "Blocks cannot be sealed without the validator's key")
+}
+
+func TestStakeKernel(t *testing.T) {
+	params := StakeNetParams
+	activeNetParams = &params
+	defer func() { activeNetParams = &MainNetParams }()
+
+	stake := Stake{make([]byte, 32), 0, TXOutput{Value: 10}, 1000}
+	assert.Equal(t, int64(0), stakeWeight(stake, 1000+params.StakeMinAge-1).Int64(), "Young stakes weigh nothing")
+	assert.Equal(t, 10*params.StakeMinAge, stakeWeight(stake, 1000+params.StakeMinAge).Int64())
+	assert.Equal(t, 10*params.StakeMaxAge, stakeWeight(stake, 1000+2*params.StakeMaxAge).Int64(), "Age counts up to StakeMaxAge")
+
+	prevHash := make([]byte, 32)
+	timestamp := int64(1000 + params.StakeMinAge)
+	assert.NotEqual(t, stakeKernelHash(prevHash, stake, timestamp), stakeKernelHash(prevHash, stake, timestamp+1), "Every second gives a new kernel")
+	assert.True(t, checkStakeKernel(prevHash, stake, timestamp, CompactToBig(0x20010000)))
+	assert.False(t, checkStakeKernel(prevHash, stake, timestamp, big.NewInt(1)))
+	assert.False(t, checkStakeKernel(prevHash, stake, 1000, CompactToBig(0x20010000)), "Young stakes never qualify")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+// CompactToBig converts the compact representation of a target used in block
+// headers into a big integer. The top byte is the length of the number in
+// bytes and the lower three bytes are its most significant bytes.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		return big.NewInt(mantissa >> (8 * (3 - exponent)))
+	}
+
+	target := big.NewInt(mantissa)
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact converts a target into its compact representation
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - exponent))
+	} else {
+		shifted := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(shifted.Uint64())
+	}
+
+	// The sign bit of the mantissa must stay clear
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// calcNextBits scales the target by how long the last retarget interval
+// actually took compared to the expected timespan. The change is clamped to
+// a factor of RetargetClamp in either direction and never gets easier than
+// PowLimit.
+func calcNextBits(lastBits uint32, actualTimespan int64) uint32 {
+	params := activeNetParams
+	targetTimespan := params.TargetSpacing * int64(params.RetargetInterval)
+
+	if actualTimespan < targetTimespan/params.RetargetClamp {
+		actualTimespan = targetTimespan / params.RetargetClamp
+	}
+	if actualTimespan > targetTimespan*params.RetargetClamp {
+		actualTimespan = targetTimespan * params.RetargetClamp
+	}
+
+	newTarget := CompactToBig(lastBits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget.Set(params.PowLimit)
+	}
+
+	return BigToCompact(newTarget)
+}
+
+// nextRequiredBits returns the difficulty a proof-of-work block built on top
+// of parent must have. It only changes every RetargetInterval blocks.
+func nextRequiredBits(tx *bolt.Tx, parent *Block) uint32 {
+	params := activeNetParams
+	if params.NoRetargeting || (parent.Height+1)%params.RetargetInterval != 0 {
+		return parent.Bits
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	first := parent
+	for i := 0; i < params.RetargetInterval-1; i++ {
+		first = DeserializeBlock(b.Get(first.PrevBlockHash))
+	}
+
+	return calcNextBits(parent.Bits, parent.Timestamp-first.Timestamp)
+}
+
+// GetRequiredBits returns the difficulty a block built on top of the block
+// with the given hash must have
+func (bc *BlockChain) GetRequiredBits(prevBlockHash []byte) uint32 {
+	var bits uint32
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		if len(prevBlockHash) == 0 {
+			// The genesis block sets its own difficulty
+			genesis := DeserializeBlock(b.Get(b.Get([]byte(genesisKey))))
+			bits = genesis.Bits
+			return nil
+		}
+
+		parent := DeserializeBlock(b.Get(prevBlockHash))
+		bits = activeConsensus.RequiredBits(tx, parent)
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return bits
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactConversion(t *testing.T) {
+	params := activeNetParams
+	target := new(big.Int).Lsh(big.NewInt(1), 240)
+
+	assert.Equal(t, params.GenesisBits, BigToCompact(target), "2^240 encodes as genesis bits")
+	assert.Equal(t, target, CompactToBig(params.GenesisBits), "Genesis bits decode to 2^240")
+
+	for _, bits := range []uint32{0x1d00ffff, 0x1b0404cb, 0x207fffff, 0x03123456} {
+		assert.Equal(t, bits, BigToCompact(CompactToBig(bits)), "Compact form round trips")
+	}
+
+	// A mantissa with the high bit set is shifted into the next exponent
+	assert.Equal(t, uint32(0x02008000), BigToCompact(big.NewInt(0x80)))
+}
+
+func TestCalcNextBits(t *testing.T) {
+	params := activeNetParams
+	targetTimespan := params.TargetSpacing * int64(params.RetargetInterval)
+	target := CompactToBig(params.GenesisBits)
+
+	same := calcNextBits(params.GenesisBits, targetTimespan)
+	assert.Equal(t, params.GenesisBits, same, "On-schedule blocks keep the difficulty")
+
+	half := CompactToBig(calcNextBits(params.GenesisBits, targetTimespan/2))
+	assert.Equal(t, new(big.Int).Div(target, big.NewInt(2)), half, "Fast blocks halve the target")
+
+	fastest := CompactToBig(calcNextBits(params.GenesisBits, 1))
+	assert.Equal(t, new(big.Int).Div(target, big.NewInt(params.RetargetClamp)), fastest, "Increase is clamped")
+
+	slowest := CompactToBig(calcNextBits(params.GenesisBits, targetTimespan*100))
+	assert.Equal(t, new(big.Int).Mul(target, big.NewInt(params.RetargetClamp)), slowest, "Decrease is clamped")
+
+	limited := CompactToBig(calcNextBits(params.PowLimitBits, targetTimespan*2))
+	assert.Equal(t, params.PowLimit, limited, "Target never exceeds the limit")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// GenesisAllocation pays an amount to an address in the genesis block
+type GenesisAllocation struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// GenesisSpec describes a genesis block. The same spec always produces the
+// same block, byte for byte.
+type GenesisSpec struct {
+	Timestamp   int64               `json:"timestamp"`
+	Bits        string              `json:"bits"`
+	ExtraData   string              `json:"extraData"`
+	Allocations []GenesisAllocation `json:"allocations"`
+}
+
+// LoadGenesisSpec reads a genesis spec from a JSON file
+func LoadGenesisSpec(path string) (*GenesisSpec, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec GenesisSpec
+	err = json.Unmarshal(content, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing genesis spec %s: %s", path, err)
+	}
+
+	return &spec, nil
+}
+
+// Block mines the genesis block described by the spec
+func (spec *GenesisSpec) Block() (*Block, error) {
+	if spec.Timestamp <= 0 {
+		return nil, fmt.Errorf("genesis spec has no timestamp")
+	}
+	if len(spec.Allocations) == 0 {
+		return nil, fmt.Errorf("genesis spec has no allocations")
+	}
+
+	bits := activeNetParams.GenesisBits
+	if spec.Bits != "" {
+		parsed, err := strconv.ParseUint(spec.Bits, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("genesis spec has invalid bits %q", spec.Bits)
+		}
+		bits = uint32(parsed)
+	}
+
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 || target.Cmp(activeNetParams.PowLimit) > 0 {
+		return nil, fmt.Errorf("genesis bits %08x are outside the range allowed on %s", bits, activeNetParams.Name)
+	}
+
+	extraData := spec.ExtraData
+	if extraData == "" {
+		extraData = activeNetParams.GenesisCoinbaseData
+	}
+
+	var outputs []TXOutput
+	for _, allocation := range spec.Allocations {
+		if !ValidateAddress(allocation.Address) {
+			return nil, fmt.Errorf("genesis allocation to invalid address %s", allocation.Address)
+		}
+		if allocation.Amount <= 0 {
+			return nil, fmt.Errorf("genesis allocation to %s has no amount", allocation.Address)
+		}
+		outputs = append(outputs, *NewTXOutput(allocation.Amount, allocation.Address))
+	}
+
+	txin := TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte(extraData)}
+	coinbase := Transaction{nil, []TXInput{txin}, outputs, 0}
+	coinbase.ID = coinbase.Hash()
+
+	return NewBlock([]*Transaction{&coinbase}, []byte{}, 0, bits, spec.Timestamp), nil
+}
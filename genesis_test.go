@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenesisSpecIsDeterministic(t *testing.T) {
+	first := string(NewWallet().GetAddress())
+	second := string(NewWallet().GetAddress())
+	spec := GenesisSpec{
+		Timestamp:   1700000000,
+		Bits:        "1f010000",
+		ExtraData:   "private network",
+		Allocations: []GenesisAllocation{{first, 100}, {second, 50}},
+	}
+
+	block, err := spec.Block()
+	assert.Nil(t, err)
+	again, err := spec.Block()
+	assert.Nil(t, err)
+
+	assert.Equal(t, block.Serialize(), again.Serialize(), "The spec produces the same block every time")
+	assert.Equal(t, int64(1700000000), block.Timestamp)
+	assert.Equal(t, uint32(0x1f010000), block.Bits)
+	assert.True(t, NewProofOfWork(block).Validate(block.Bits))
+
+	coinbase := block.Transactions[0]
+	assert.True(t, coinbase.IsCoinbase())
+	assert.Equal(t, []byte("private network"), coinbase.Vin[0].PubKey)
+	assert.Len(t, coinbase.Vout, 2)
+	assert.Equal(t, 100, coinbase.Vout[0].Value)
+	assert.Equal(t, 50, coinbase.Vout[1].Value)
+}
+
+func TestGenesisSpecValidation(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+
+	_, err := (&GenesisSpec{Allocations: []GenesisAllocation{{address, 1}}}).Block()
+	assert.NotNil(t, err, "A timestamp is required")
+
+	_, err = (&GenesisSpec{Timestamp: 1, Bits: "21010000", Allocations: []GenesisAllocation{{address, 1}}}).Block()
+	assert.NotNil(t, err, "Bits above the limit are rejected")
+
+	_, err = (&GenesisSpec{Timestamp: 1}).Block()
+	assert.NotNil(t, err, "Allocations are required")
+}
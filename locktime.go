@@ -0,0 +1,51 @@
+package main
+
+// lockTimeThreshold separates lock times that are block heights, below it,
+// from lock times that are unix timestamps
+const lockTimeThreshold = 500000000
+
+// The sequence of an input holds its relative lock time: the number of
+// blocks, or of 512 second intervals, that must pass after the output it
+// spends was created before the input may be included in a block
+const (
+	// SequenceLockTimeDisabled turns the relative lock time of an input off
+	SequenceLockTimeDisabled uint32 = 1 << 31
+	// SequenceLockTimeIsSeconds makes the relative lock time count 512
+	// second intervals instead of blocks
+	SequenceLockTimeIsSeconds uint32 = 1 << 22
+	// SequenceLockTimeMask selects the value of the relative lock time
+	SequenceLockTimeMask uint32 = 0x0000ffff
+	// sequenceLockTimeGranularity is the log2 of the seconds in an interval
+	sequenceLockTimeGranularity = 9
+)
+
+// IsFinal reports whether the transaction may be included in a block at the
+// height whose parent has the median time. A lock time of zero never locks,
+// other lock times must be below the height or the median time.
+func (tx *Transaction) IsFinal(height int, medianTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	if tx.LockTime < lockTimeThreshold {
+		return tx.LockTime < int64(height)
+	}
+
+	return tx.LockTime < medianTime
+}
+
+// sequenceLockSatisfied reports whether an input with the sequence, which
+// spends an output created at prevHeight and prevTime, may be included in a
+// block at the height whose parent has the median time
+func sequenceLockSatisfied(sequence uint32, prevHeight int, prevTime int64, height int, medianTime int64) bool {
+	if sequence&SequenceLockTimeDisabled != 0 {
+		return true
+	}
+
+	value := sequence & SequenceLockTimeMask
+	if sequence&SequenceLockTimeIsSeconds != 0 {
+		return medianTime >= prevTime+int64(value)<<sequenceLockTimeGranularity
+	}
+
+	return height >= prevHeight+int(value)
+}
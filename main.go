@@ -11,6 +11,6 @@ func main() {
 		fmt.Printf("NODE_ID env. var is not set!")
 		os.Exit(1)
 	}
-	cli := CLI{WalletsInstance(nodeID)}
+	cli := CLI{}
 	cli.Run(nodeID)
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Mempool holds the transactions waiting to be mined, keyed by their hex
+// encoded ID. The P2P handlers, the RPC server and the miners share it, so
+// every access takes its lock.
+type Mempool struct {
+	mu  sync.RWMutex
+	txs map[string]Transaction
+}
+
+// NewMempool creates an empty Mempool
+func NewMempool() *Mempool {
+	return &Mempool{txs: make(map[string]Transaction)}
+}
+
+// Add stores a transaction
+func (m *Mempool) Add(tx Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.txs[hex.EncodeToString(tx.ID)] = tx
+}
+
+// Remove drops the transaction with the ID
+func (m *Mempool) Remove(txID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.txs, txID)
+}
+
+// Get returns the transaction with the ID, if it is in the mempool
+func (m *Mempool) Get(txID string) (Transaction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tx, ok := m.txs[txID]
+
+	return tx, ok
+}
+
+// Count returns the number of transactions in the mempool
+func (m *Mempool) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.txs)
+}
+
+// Snapshot returns a copy of the transactions in the mempool
+func (m *Mempool) Snapshot() map[string]Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	txs := make(map[string]Transaction, len(m.txs))
+	for id, tx := range m.txs {
+		txs[id] = tx
+	}
+
+	return txs
+}
+
+// mempoolEntry is a transaction waiting in the mempool with its fee
+type mempoolEntry struct {
+	tx  *Transaction
+	fee int
+}
+
+// feeRate returns the fee the transaction pays per byte
+func (e mempoolEntry) feeRate() float64 {
+	return float64(e.fee) / float64(len(e.tx.Serialize()))
+}
+
+// checkMempoolTransaction checks a transaction received from a peer before
+// it enters the mempool, including its scripts, and returns the fee it pays
+func checkMempoolTransaction(bc *BlockChain, tx *Transaction) (int, error) {
+	err := CheckTransactionSanity(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	if tx.IsCoinbase() {
+		return 0, ruleError(RejectBadTransaction, "coinbase %x is only valid in a block", tx.ID)
+	}
+
+	UTXOSet := UTXOSet{bc}
+
+	fee, err := UTXOSet.CalcFee(tx)
+	if err != nil {
+		return 0, ruleError(RejectMissingInput, "%s", err)
+	}
+
+	err = bc.VerifyTransaction(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	nextHeight := bc.GetBestHeight() + 1
+	if UTXOSet.SpendsImmatureCoinbase(tx, nextHeight) {
+		return 0, ruleError(RejectImmatureCoinbase, "transaction %x spends an immature coinbase", tx.ID)
+	}
+
+	medianTime := bc.MedianTimePast()
+	if !tx.IsFinal(nextHeight, medianTime) || UTXOSet.SequenceLocked(tx, nextHeight, medianTime) {
+		return 0, ruleError(RejectNonFinal, "transaction %x cannot be included in the next block yet", tx.ID)
+	}
+
+	if fee < 0 {
+		return 0, ruleError(RejectBadFee, "outputs of transaction %x exceed its inputs by %d", tx.ID, -fee)
+	}
+
+	return fee, nil
+}
+
+// selectMempoolTransactions picks the mempool transactions for the next
+// block, highest fee rate first, and returns them with the total fee they
+// pay. Transactions whose inputs are no longer unspent or that pay a negative
+// fee are dropped from the mempool; transactions conflicting with an already
+// selected one are left for a later block.
+func selectMempoolTransactions(bc *BlockChain) ([]*Transaction, int) {
+	UTXOSet := UTXOSet{bc}
+	nextHeight := bc.GetBestHeight() + 1
+	medianTime := bc.MedianTimePast()
+	var entries []mempoolEntry
+
+	for id, tx := range mempool.Snapshot() {
+		tx := tx
+
+		fee, err := UTXOSet.CalcFee(&tx)
+		if err != nil || fee < 0 || bc.VerifyTransaction(&tx) != nil {
+			fmt.Printf("Dropping invalid transaction %s from mempool\n", id)
+			mempool.Remove(id)
+			continue
+		}
+
+		// Transactions spending a coinbase that is not mature yet wait
+		if UTXOSet.SpendsImmatureCoinbase(&tx, nextHeight) {
+			continue
+		}
+
+		// So do transactions whose lock times have not passed yet
+		if !tx.IsFinal(nextHeight, medianTime) || UTXOSet.SequenceLocked(&tx, nextHeight, medianTime) {
+			continue
+		}
+
+		entries = append(entries, mempoolEntry{&tx, fee})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].feeRate() > entries[j].feeRate()
+	})
+
+	var txs []*Transaction
+	fees := 0
+	spent := make(map[string]bool)
+
+Entries:
+	for _, entry := range entries {
+		for _, vin := range entry.tx.Vin {
+			if spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] {
+				continue Entries
+			}
+		}
+
+		for _, vin := range entry.tx.Vin {
+			spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+
+		txs = append(txs, entry.tx)
+		fees += entry.fee
+	}
+
+	return txs, fees
+}
+
+// mineMempool mines blocks from the mempool until it is empty and announces
+// them to the known nodes. The coinbase collects the fees of the block.
+func mineMempool(bc *BlockChain) {
+	for mempool.Count() > 0 {
+		txs, fees := selectMempoolTransactions(bc)
+
+		if len(txs) == 0 {
+			fmt.Println("No transactions can be mined yet! Waiting for new ones...")
+			return
+		}
+
+		cbTx := NewCoinbaseTX(miningAddress, "", bc.GetBestHeight()+1, fees)
+		txs = append([]*Transaction{cbTx}, txs...)
+
+		newBlock, err := bc.MineBlock(txs)
+		if err == ErrTipChanged {
+			fmt.Println("Chain tip changed, mining on top of the new tip")
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Stopped mining: %s\n", err)
+			return
+		}
+
+		fmt.Printf("New block is mined! Collected %d in fees\n", fees)
+		relayMinedBlock(newBlock)
+	}
+}
+
+// relayMinedBlock removes the transactions of a block mined by this node or
+// its miners from the mempool and announces the block to the known nodes
+func relayMinedBlock(block *Block) {
+	for _, tx := range block.Transactions {
+		mempool.Remove(hex.EncodeToString(tx.ID))
+	}
+
+	for _, node := range knownNodes {
+		if node != nodeAddress {
+			sendInv(node, "block", [][]byte{block.Hash})
+		}
+	}
+}
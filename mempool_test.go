@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMempoolTransactionScripts(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	bc := newTestBlockChain(t, string(alice.GetAddress()))
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+
+	tx := &Transaction{nil, []TXInput{{Txid: coinbase.ID, Vout: 0}}, []TXOutput{*NewTXOutput(coinbase.Vout[0].Value, string(bob.GetAddress()))}, 0}
+	tx.ID = tx.Hash()
+
+	_, err = checkMempoolTransaction(bc, tx)
+	assert.Equal(t, RejectBadSignature, err.(RuleError).Code, "An unsigned transaction is rejected")
+
+	assert.Nil(t, bob.SignInput(tx, 0, coinbase.Vout[0], SigHashAll))
+	_, err = checkMempoolTransaction(bc, tx)
+	assert.Equal(t, RejectBadSignature, err.(RuleError).Code, "A signature of another key is rejected")
+
+	assert.Nil(t, alice.SignInput(tx, 0, coinbase.Vout[0], SigHashAll))
+	_, err = checkMempoolTransaction(bc, tx)
+	assert.Equal(t, RejectImmatureCoinbase, err.(RuleError).Code, "The signature is accepted")
+
+	tx.Vin[0].Txid = []byte("unknown")
+	err = bc.VerifyTransaction(tx)
+	assert.Equal(t, RejectMissingInput, err.(RuleError).Code, "Spending an unknown transaction is rejected, not a panic")
+}
+
+// TestMempoolConcurrentAccess is meant to be run with -race, which needs
+// -gcflags=all=-d=checkptr=0 for bolt: block templates are built, and
+// invalid transactions dropped, while transactions arrive
+func TestMempoolConcurrentAccess(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc := newTestBlockChain(t, address)
+	defer func() { mempool = NewMempool() }()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			// Spends an output that does not exist
+			tx := Transaction{nil, []TXInput{{Txid: []byte{byte(i)}, Vout: 0}}, []TXOutput{*NewTXOutput(1, address)}, 0}
+			tx.ID = tx.Hash()
+			mempool.Add(tx)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			assert.Empty(t, bc.GetBlockTemplate().Transactions)
+		}
+	}()
+	wg.Wait()
+
+	bc.GetBlockTemplate()
+	assert.Equal(t, 0, mempool.Count(), "Invalid transactions are dropped")
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"fmt"
+	"log"
 )
 
 // MerkleTree represent a Merkle tree
 type MerkleTree struct {
 	RootNode *MerkleNode
+
+	leaves int
 }
 
 // MerkleNode represent a Merkle tree node
@@ -16,12 +21,23 @@ type MerkleNode struct {
 	Data  []byte
 }
 
-// NewMerkleTree creates a new Merkle tree from a sequence of data
+// MerkleProof proves that a leaf is part of a tree. Siblings holds the
+// hashes next to the path from the leaf to the root, from the bottom up. The
+// bits of Index, lowest first, tell whether the path goes through the left
+// (0) or the right (1) node at each level.
+type MerkleProof struct {
+	Index    int
+	Siblings [][]byte
+}
+
+// NewMerkleTree creates a new Merkle tree from a sequence of data. Every
+// level with an odd number of nodes, including the leaves, is padded with a
+// copy of its last node.
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+	if len(data) == 0 {
+		log.Panic("ERROR: A Merkle tree needs at least one leaf")
 	}
 
 	for _, datum := range data {
@@ -29,9 +45,13 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = append(nodes, *node)
 	}
 
-	for i := 0; i < len(data)/2; i++ {
+	for len(nodes) > 1 || nodes[0].Left == nil {
 		var newLevel []MerkleNode
 
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		for j := 0; j < len(nodes); j += 2 {
 			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
 			newLevel = append(newLevel, *node)
@@ -40,11 +60,55 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = newLevel
 	}
 
-	mTree := MerkleTree{&nodes[0]}
+	mTree := MerkleTree{&nodes[0], len(data)}
 
 	return &mTree
 }
 
+// Proof returns the proof that the leaf at index is part of the tree
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, fmt.Errorf("leaf %d is not in a tree of %d leaves", index, t.leaves)
+	}
+
+	depth := 0
+	for node := t.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+
+	siblings := make([][]byte, depth)
+	node := t.RootNode
+	for level := depth - 1; level >= 0; level-- {
+		if (index>>level)&1 == 0 {
+			siblings[level] = node.Right.Data
+			node = node.Left
+		} else {
+			siblings[level] = node.Left.Data
+			node = node.Right
+		}
+	}
+
+	return &MerkleProof{index, siblings}, nil
+}
+
+// VerifyMerkleProof checks that the proof links the leaf data to the root
+func VerifyMerkleProof(root, leaf []byte, proof *MerkleProof) bool {
+	if proof.Index < 0 || proof.Index>>len(proof.Siblings) != 0 {
+		return false
+	}
+
+	hash := sha256.Sum256(leaf)
+	for level, sibling := range proof.Siblings {
+		if (proof.Index>>level)&1 == 0 {
+			hash = sha256.Sum256(append(hash[:], sibling...))
+		} else {
+			hash = sha256.Sum256(append(append([]byte{}, sibling...), hash[:]...))
+		}
+	}
+
+	return bytes.Equal(hash[:], root)
+}
+
 // NewMerkleNode creates a new Merkle tree node
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	mNode := MerkleNode{}
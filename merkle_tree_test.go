@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"testing"
@@ -73,3 +74,80 @@ func TestNewMerkleTree(t *testing.T) {
 
 	assert.Equal(t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
 }
+
+// merkleRoot computes the root of a tree level by level, padding every odd
+// level with a copy of its last hash
+func merkleRoot(data [][]byte) []byte {
+	var level [][]byte
+	for _, datum := range data {
+		hash := sha256.Sum256(datum)
+		level = append(level, hash[:])
+	}
+
+	// A single leaf is paired with itself too
+	for first := true; first || len(level) > 1; first = false {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			hash := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, hash[:])
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+func TestMerkleTreeAnySize(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		var data [][]byte
+		for i := 0; i < size; i++ {
+			data = append(data, []byte(fmt.Sprintf("node%d", i)))
+		}
+
+		mTree := NewMerkleTree(data)
+		assert.Equal(t, merkleRoot(data), mTree.RootNode.Data, "Root of %d leaves is correct", size)
+
+		for i := range data {
+			proof, err := mTree.Proof(i)
+			assert.Nil(t, err)
+			assert.True(t, VerifyMerkleProof(mTree.RootNode.Data, data[i], proof), "Proof of leaf %d of %d verifies", i, size)
+			assert.False(t, VerifyMerkleProof(mTree.RootNode.Data, []byte("other"), proof), "Proof of leaf %d of %d rejects other data", i, size)
+		}
+
+		_, err := mTree.Proof(size)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestMerkleProofOfSixLeaves(t *testing.T) {
+	var data [][]byte
+	for i := 0; i < 6; i++ {
+		data = append(data, []byte(fmt.Sprintf("node%d", i)))
+	}
+	mTree := NewMerkleTree(data)
+
+	leaves := make([]*MerkleNode, 6)
+	for i := range data {
+		leaves[i] = NewMerkleNode(nil, nil, data[i])
+	}
+	n01 := NewMerkleNode(leaves[0], leaves[1], nil)
+	n23 := NewMerkleNode(leaves[2], leaves[3], nil)
+	n45 := NewMerkleNode(leaves[4], leaves[5], nil)
+	n0123 := NewMerkleNode(n01, n23, nil)
+	n4545 := NewMerkleNode(n45, n45, nil)
+	root := NewMerkleNode(n0123, n4545, nil)
+	assert.Equal(t, root.Data, mTree.RootNode.Data, "The level of 3 nodes is padded")
+
+	proof, err := mTree.Proof(4)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{leaves[5].Data, n45.Data, n0123.Data}, proof.Siblings)
+
+	proof.Index = 5
+	assert.False(t, VerifyMerkleProof(mTree.RootNode.Data, data[4], proof), "The proof is bound to the index")
+	proof.Index = 4 + 8
+	assert.False(t, VerifyMerkleProof(mTree.RootNode.Data, data[4], proof), "Indexes beyond the proof are rejected")
+}
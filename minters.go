@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/boltdb/bolt"
+)
+
+// MinterPolicy restricts block production to a list of minters. Every
+// block but the genesis must be signed by one of them, and a minter may sign
+// at most MaxBlocks blocks within Window seconds. A MaxBlocks of 0 sets no
+// limit.
+type MinterPolicy struct {
+	// Minters are the hex encoded public keys of the authorized minters
+	Minters   []string `json:"minters"`
+	MaxBlocks int      `json:"maxblocks"`
+	Window    int64    `json:"window"`
+}
+
+// LoadMinterPolicy reads a minter policy from a JSON file
+func LoadMinterPolicy(path string) (*MinterPolicy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy MinterPolicy
+	err = json.Unmarshal(content, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("parsing minter policy %s: %s", path, err)
+	}
+
+	return &policy, nil
+}
+
+// AuthorizedMinting wraps a consensus engine so that only the minters of a
+// MinterPolicy can produce blocks. Blocks the engine does not sign itself
+// are signed with the key of the minter the coinbase pays to.
+type AuthorizedMinting struct {
+	ConsensusEngine
+
+	policy  *MinterPolicy
+	minters [][]byte
+	wallets *Wallets
+}
+
+// NewAuthorizedMinting applies the policy to the engine. Blocks can only be
+// sealed by minters whose keys are in wallets, which may be nil on nodes
+// that only verify.
+func NewAuthorizedMinting(engine ConsensusEngine, policy *MinterPolicy, wallets *Wallets) (*AuthorizedMinting, error) {
+	if len(policy.Minters) == 0 {
+		return nil, fmt.Errorf("the minter policy authorizes no minters")
+	}
+	if policy.MaxBlocks < 0 || (policy.MaxBlocks > 0 && policy.Window <= 0) {
+		return nil, fmt.Errorf("the minter policy needs a positive window for its block limit")
+	}
+
+	m := &AuthorizedMinting{ConsensusEngine: engine, policy: policy, wallets: wallets}
+	for _, minter := range policy.Minters {
+		pubKey, err := hex.DecodeString(minter)
+		if err != nil || len(pubKey) == 0 {
+			return nil, fmt.Errorf("invalid minter public key %q", minter)
+		}
+
+		m.minters = append(m.minters, pubKey)
+	}
+
+	return m, nil
+}
+
+// Seal lets the wrapped engine seal the block and signs it unless the
+// engine already did
+func (m *AuthorizedMinting) Seal(ctx context.Context, bc *BlockChain, block *Block, threads int) error {
+	if block.Height == 0 {
+		return m.ConsensusEngine.Seal(ctx, bc, block, threads)
+	}
+
+	pubKeyHash := block.Transactions[0].Vout[0].PubKeyHash
+	wallet := m.findMinter(pubKeyHash)
+	if wallet == nil {
+		return fmt.Errorf("minter %x is not authorized or its key is not in the wallet", pubKeyHash)
+	}
+
+	if bc != nil {
+		err := bc.db.View(func(tx *bolt.Tx) error {
+			return m.checkRateLimit(tx, block.PrevBlockHash, wallet.PublicKey, block.Timestamp)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := m.ConsensusEngine.Seal(ctx, bc, block, threads)
+	if err != nil {
+		return err
+	}
+
+	if len(block.Signer) == 0 {
+		return signBlock(block, wallet)
+	}
+
+	return nil
+}
+
+// VerifySeal checks the seal of the wrapped engine and that an authorized
+// minter signed the block
+func (m *AuthorizedMinting) VerifySeal(block *Block) error {
+	err := m.ConsensusEngine.VerifySeal(block)
+	if err != nil || block.Height == 0 {
+		return err
+	}
+
+	if !m.isMinter(block.Signer) {
+		return ruleError(RejectUnauthorizedMinter, "block %x is signed by %x, which is not an authorized minter", block.Hash, block.Signer)
+	}
+
+	if !verifyBlockSignature(block) {
+		return ruleError(RejectBadSigner, "signature of block %x is not valid", block.Hash)
+	}
+
+	return nil
+}
+
+// VerifyState checks the state of the wrapped engine and the rate limit of
+// the block's minter
+func (m *AuthorizedMinting) VerifyState(tx *bolt.Tx, block *Block) error {
+	err := m.ConsensusEngine.VerifyState(tx, block)
+	if err != nil || block.Height == 0 {
+		return err
+	}
+
+	return m.checkRateLimit(tx, block.PrevBlockHash, block.Signer, block.Timestamp)
+}
+
+// checkRateLimit counts the blocks the minter signed within the window
+// before the timestamp, going back from prevBlockHash, and fails when one
+// more would exceed MaxBlocks
+func (m *AuthorizedMinting) checkRateLimit(tx *bolt.Tx, prevBlockHash, minter []byte, timestamp int64) error {
+	if m.policy.MaxBlocks == 0 {
+		return nil
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	cutoff := timestamp - m.policy.Window
+	count := 1
+
+	for blockHash := prevBlockHash; len(blockHash) > 0; {
+		block := DeserializeBlock(b.Get(blockHash))
+		if block.Timestamp <= cutoff {
+			break
+		}
+
+		if bytes.Equal(block.Signer, minter) {
+			count++
+		}
+		blockHash = block.PrevBlockHash
+	}
+
+	if count > m.policy.MaxBlocks {
+		return ruleError(RejectMinterRateLimit, "minter %x would sign %d blocks within %d seconds, at most %d are allowed", minter, count, m.policy.Window, m.policy.MaxBlocks)
+	}
+
+	return nil
+}
+
+// isMinter reports whether the public key belongs to an authorized minter
+func (m *AuthorizedMinting) isMinter(pubKey []byte) bool {
+	for _, minter := range m.minters {
+		if bytes.Equal(minter, pubKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findMinter returns the wallet of the public key hash if it is an
+// authorized minter, or nil
+func (m *AuthorizedMinting) findMinter(pubKeyHash []byte) *Wallet {
+	if m.wallets == nil {
+		return nil
+	}
+
+	for _, wallet := range m.wallets.Wallets {
+		if bytes.Equal(HashPubKey(wallet.PublicKey), pubKeyHash) && m.isMinter(wallet.PublicKey) {
+			return wallet
+		}
+	}
+
+	return nil
+}
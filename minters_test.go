@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizedMinting(t *testing.T) {
+	minter, outsider := NewWallet(), NewWallet()
+	minterAddress, outsiderAddress := string(minter.GetAddress()), string(outsider.GetAddress())
+	wallets := &Wallets{
+		Wallets: map[string]*Wallet{minterAddress: minter, outsiderAddress: outsider},
+		Alias:   map[string]string{},
+	}
+	policy := &MinterPolicy{Minters: []string{hex.EncodeToString(minter.PublicKey)}, MaxBlocks: 2, Window: 600}
+
+	engine, err := NewAuthorizedMinting(ProofOfWorkEngine{}, policy, wallets)
+	assert.Nil(t, err)
+	assert.True(t, isProofOfWork(engine), "Miners and pools can work for the wrapped proof-of-work engine")
+
+	coinbase := NewCoinbaseTX(minterAddress, "", 1, 0)
+	block := newBlockTemplate([]*Transaction{coinbase}, make([]byte, 32), 1, activeNetParams.GenesisBits, time.Now().Unix())
+	assert.Nil(t, engine.Seal(context.Background(), nil, block, 1))
+	assert.Equal(t, minter.PublicKey, block.Signer, "Proof-of-work blocks are signed by the minter")
+	assert.Nil(t, engine.VerifySeal(block))
+
+	assert.Nil(t, signBlock(block, outsider))
+	err = engine.VerifySeal(block)
+	assert.Equal(t, RejectUnauthorizedMinter, err.(RuleError).Code, "Blocks of other keys are rejected")
+
+	block.Signer, block.Signature = nil, nil
+	err = engine.VerifySeal(block)
+	assert.Equal(t, RejectUnauthorizedMinter, err.(RuleError).Code, "Unsigned blocks are rejected")
+
+	coinbase = NewCoinbaseTX(outsiderAddress, "", 1, 0)
+	block = newBlockTemplate([]*Transaction{coinbase}, make([]byte, 32), 1, activeNetParams.GenesisBits, time.Now().Unix())
+	assert.NotNil(t, engine.Seal(context.Background(), nil, block, 1), "Outsiders cannot mint")
+
+	_, err = NewAuthorizedMinting(ProofOfWorkEngine{}, &MinterPolicy{}, wallets)
+	assert.NotNil(t, err, "A policy needs minters")
+	_, err = NewAuthorizedMinting(ProofOfWorkEngine{}, &MinterPolicy{Minters: []string{"zz"}}, wallets)
+	assert.NotNil(t, err, "Minters are hex encoded public keys")
+	_, err = NewAuthorizedMinting(ProofOfWorkEngine{}, &MinterPolicy{Minters: policy.Minters, MaxBlocks: 1}, wallets)
+	assert.NotNil(t, err, "A block limit needs a window")
+}
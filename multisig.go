@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+// MultiSigWallet is an m-of-n multisig address. It holds no keys, only the
+// redeem script its coins are locked with.
+type MultiSigWallet struct {
+	Required     int
+	PubKeys      [][]byte
+	RedeemScript []byte
+}
+
+// NewMultiSigWallet creates a multisig wallet needing required signatures of
+// the public keys. The keys are sorted, so every keyholder gets the same
+// address whatever order they list the keys in.
+func NewMultiSigWallet(required int, pubKeys [][]byte) (*MultiSigWallet, error) {
+	sorted := append([][]byte{}, pubKeys...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	for i := 1; i < len(sorted); i++ {
+		if bytes.Equal(sorted[i-1], sorted[i]) {
+			return nil, fmt.Errorf("public key %x is listed twice", sorted[i])
+		}
+	}
+
+	redeemScript, err := MultiSigScript(required, sorted)
+	if err != nil {
+		return nil, err
+	}
+	if len(redeemScript) > maxScriptElementSize {
+		return nil, fmt.Errorf("redeem script of %d keys is %d bytes, more than the %d a script hash spend can reveal", len(sorted), len(redeemScript), maxScriptElementSize)
+	}
+
+	return &MultiSigWallet{required, sorted, redeemScript}, nil
+}
+
+// GetAddress returns the script hash address of the wallet
+func (w MultiSigWallet) GetAddress() []byte {
+	return ScriptAddress(w.RedeemScript)
+}
+
+// MultiSigSpend is a transaction spending multisig outputs that collects the
+// signatures of the keyholders. It is passed from one keyholder to the next
+// as a file until enough of them signed.
+type MultiSigSpend struct {
+	Tx Transaction
+	// Spent are the outputs the inputs of Tx spend, in the same order
+	Spent        []TXOutput
+	RedeemScript []byte
+	// Signatures holds for each input a signature per public key of the
+	// redeem script, nil where the keyholder has not signed yet
+	Signatures [][][]byte
+}
+
+// NewMultiSigTransaction creates an unsigned spend of amount from the
+// multisig wallet to the address. The inputs cover the amount plus the fee,
+// the change goes back to the multisig address.
+func NewMultiSigTransaction(wallet *MultiSigWallet, to string, amount, fee int, UTXOSet *UTXOSet) (*MultiSigSpend, error) {
+	var inputs []TXInput
+	var spent []TXOutput
+
+	from := string(wallet.GetAddress())
+	lockingScript := NewTXOutput(0, from).LockingScript()
+	acc, validOutputs := UTXOSet.FindSpendableScriptOutputs(lockingScript, amount+fee)
+	if acc < amount+fee {
+		return nil, fmt.Errorf("not enough funds: %s has %d spendable, %d are needed", from, acc, amount+fee)
+	}
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		prevTx, err := UTXOSet.BlockChain.FindTransaction(txID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range outs {
+			inputs = append(inputs, TXInput{Txid: txID, Vout: out})
+			spent = append(spent, prevTx.Vout[out])
+		}
+	}
+
+	outputs := []TXOutput{*NewTXOutput(amount, to)}
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from)) // a change
+	}
+
+	tx := Transaction{nil, inputs, outputs, 0}
+	tx.ID = tx.Hash()
+
+	return NewMultiSigSpend(tx, spent, wallet.RedeemScript), nil
+}
+
+// NewMultiSigSpend starts collecting signatures for a transaction whose
+// inputs spend the outputs spent, all locked by the redeem script
+func NewMultiSigSpend(tx Transaction, spent []TXOutput, redeemScript []byte) *MultiSigSpend {
+	_, pubKeys, _ := extractMultiSig(redeemScript)
+
+	signatures := make([][][]byte, len(tx.Vin))
+	for i := range signatures {
+		signatures[i] = make([][]byte, len(pubKeys))
+	}
+
+	return &MultiSigSpend{tx, spent, redeemScript, signatures}
+}
+
+// LoadMultiSigSpend reads a spend from a JSON file
+func LoadMultiSigSpend(path string) (*MultiSigSpend, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var spend MultiSigSpend
+	err = json.Unmarshal(content, &spend)
+	if err != nil {
+		return nil, fmt.Errorf("parsing multisig spend %s: %s", path, err)
+	}
+
+	err = spend.check()
+	if err != nil {
+		return nil, fmt.Errorf("multisig spend %s: %s", path, err)
+	}
+
+	return &spend, nil
+}
+
+// SaveToFile writes the spend to a JSON file
+func (s *MultiSigSpend) SaveToFile(path string) {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// check makes sure the parts of the spend fit together: a multisig redeem
+// script, a transaction matching its ID, a spent output and a signature slot
+// per key for every input, and every spent output locked by the redeem
+// script
+func (s *MultiSigSpend) check() error {
+	_, pubKeys, ok := extractMultiSig(s.RedeemScript)
+	if !ok {
+		return fmt.Errorf("redeem script is not a multisig script")
+	}
+
+	if !bytes.Equal(s.Tx.ID, s.Tx.Hash()) {
+		return fmt.Errorf("transaction ID %x does not match its hash", s.Tx.ID)
+	}
+
+	if len(s.Spent) != len(s.Tx.Vin) || len(s.Signatures) != len(s.Tx.Vin) {
+		return fmt.Errorf("transaction has %d inputs, but %d spent outputs and %d signature lists", len(s.Tx.Vin), len(s.Spent), len(s.Signatures))
+	}
+
+	lockingScript := PayToScriptHashScript(ScriptHash(s.RedeemScript))
+	for i, out := range s.Spent {
+		if !bytes.Equal(out.LockingScript(), lockingScript) {
+			return fmt.Errorf("input %d does not spend an output of the multisig address", i)
+		}
+		if len(s.Signatures[i]) != len(pubKeys) {
+			return fmt.Errorf("input %d has %d signatures for %d keys", i, len(s.Signatures[i]), len(pubKeys))
+		}
+	}
+
+	return nil
+}
+
+// Address returns the multisig address the spend spends from
+func (s *MultiSigSpend) Address() string {
+	return string(ScriptAddress(s.RedeemScript))
+}
+
+// Required returns the number of signatures each input needs
+func (s *MultiSigSpend) Required() int {
+	required, _, _ := extractMultiSig(s.RedeemScript)
+
+	return required
+}
+
+// Signed returns the number of keyholders that signed every input
+func (s *MultiSigSpend) Signed() int {
+	_, pubKeys, _ := extractMultiSig(s.RedeemScript)
+
+	signed := 0
+	for key := range pubKeys {
+		all := true
+		for i := range s.Tx.Vin {
+			all = all && s.Signatures[i][key] != nil
+		}
+		if all {
+			signed++
+		}
+	}
+
+	return signed
+}
+
+// Sign adds the signatures of the wallet to every input. It fails when the
+// wallet's key is not one of the keys of the redeem script.
+func (s *MultiSigSpend) Sign(wallet *Wallet) error {
+	err := s.check()
+	if err != nil {
+		return err
+	}
+
+	_, pubKeys, _ := extractMultiSig(s.RedeemScript)
+	key := -1
+	for i, pubKey := range pubKeys {
+		if bytes.Equal(pubKey, wallet.PublicKey) {
+			key = i
+		}
+	}
+	if key < 0 {
+		return fmt.Errorf("key of %s is not one of the keys of %s", wallet.GetAddress(), s.Address())
+	}
+
+	for i := range s.Tx.Vin {
+		signature, err := s.Tx.InputSignature(wallet.PrivateKey, i, s.Spent[i], SigHashAll)
+		if err != nil {
+			return err
+		}
+
+		s.Signatures[i][key] = signature
+	}
+
+	return nil
+}
+
+// Finalize builds the signature scripts from the collected signatures and
+// returns the transaction, ready to be broadcast. Every input needs the
+// required number of signatures.
+func (s *MultiSigSpend) Finalize() (*Transaction, error) {
+	err := s.check()
+	if err != nil {
+		return nil, err
+	}
+
+	required := s.Required()
+	tx := s.Tx
+	tx.Vin = append([]TXInput{}, s.Tx.Vin...)
+
+	for i := range tx.Vin {
+		var scriptSig []byte
+		count := 0
+		for _, signature := range s.Signatures[i] {
+			if signature != nil && count < required {
+				scriptSig = pushData(scriptSig, signature)
+				count++
+			}
+		}
+		if count < required {
+			return nil, fmt.Errorf("input %d has %d of the %d required signatures", i, count, required)
+		}
+
+		tx.Vin[i].ScriptSig = pushData(scriptSig, s.RedeemScript)
+
+		err := VerifyScript(tx.Vin[i].ScriptSig, s.Spent[i].LockingScript(), &tx, i, s.Spent[i])
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %s", i, err)
+		}
+	}
+
+	return &tx, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiSigWallet(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+
+	wallet, err := NewMultiSigWallet(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	assert.Nil(t, err)
+	reordered, err := NewMultiSigWallet(2, [][]byte{carol.PublicKey, alice.PublicKey, bob.PublicKey})
+	assert.Nil(t, err)
+	assert.Equal(t, wallet.GetAddress(), reordered.GetAddress(), "The order of the keys does not matter")
+	assert.True(t, IsScriptAddress(string(wallet.GetAddress())))
+
+	_, err = NewMultiSigWallet(2, [][]byte{alice.PublicKey, alice.PublicKey})
+	assert.NotNil(t, err, "Duplicate keys")
+	_, err = NewMultiSigWallet(3, [][]byte{alice.PublicKey, bob.PublicKey})
+	assert.NotNil(t, err, "More signatures than keys")
+
+	var keys [][]byte
+	for i := 0; i < 16; i++ {
+		keys = append(keys, NewWallet().PublicKey)
+	}
+	_, err = NewMultiSigWallet(2, keys)
+	assert.NotNil(t, err, "A redeem script too large to reveal")
+}
+
+func TestMultiSigSpend(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+	wallet, err := NewMultiSigWallet(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	assert.Nil(t, err)
+
+	tx, prevTXs := testScriptSpend(NewTXOutput(10, string(wallet.GetAddress())).ScriptPubKey)
+	spent := prevTXs[hex.EncodeToString(tx.Vin[0].Txid)].Vout[0]
+	spend := NewMultiSigSpend(*tx, []TXOutput{spent}, wallet.RedeemScript)
+
+	assert.NotNil(t, spend.Sign(NewWallet()), "Only keyholders sign")
+	assert.Nil(t, spend.Sign(carol))
+	assert.Equal(t, 1, spend.Signed())
+	_, err = spend.Finalize()
+	assert.NotNil(t, err, "One signature is not enough")
+
+	path := filepath.Join(t.TempDir(), "spend.json")
+	spend.SaveToFile(path)
+	spend, err = LoadMultiSigSpend(path)
+	assert.Nil(t, err)
+
+	assert.Nil(t, spend.Sign(alice))
+	assert.Equal(t, 2, spend.Signed())
+	final, err := spend.Finalize()
+	assert.Nil(t, err)
+	assert.True(t, final.Verify(prevTXs))
+
+	final.Vout[0].Value = 8
+	assert.False(t, final.Verify(prevTXs), "The signatures cover the outputs")
+}
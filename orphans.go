@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxOrphanBlocks bounds the number of blocks kept while their parent is missing
+const maxOrphanBlocks = 100
+
+// orphanExpiry is how long an orphan waits for its parent before it is dropped
+const orphanExpiry = 20 * time.Minute
+
+type orphanBlock struct {
+	block      *Block
+	expiration time.Time
+}
+
+// OrphanPool keeps blocks whose parent is not known yet, indexed by the
+// hash of the missing parent
+type OrphanPool struct {
+	mu      sync.Mutex
+	orphans map[string]*orphanBlock
+	byPrev  map[string][]*orphanBlock
+}
+
+// orphans is the node's pool of blocks waiting for their parent
+var orphans = NewOrphanPool()
+
+// NewOrphanPool creates an empty OrphanPool
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{
+		orphans: make(map[string]*orphanBlock),
+		byPrev:  make(map[string][]*orphanBlock),
+	}
+}
+
+// Add stores an orphan block, dropping expired orphans and, if the pool is
+// still full, an arbitrary one to make room
+func (p *OrphanPool) Add(block *Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := p.orphans[hash]; ok {
+		return
+	}
+
+	now := time.Now()
+	for _, orphan := range p.orphans {
+		if now.After(orphan.expiration) {
+			p.remove(orphan)
+		}
+	}
+
+	if len(p.orphans) >= maxOrphanBlocks {
+		for _, orphan := range p.orphans {
+			p.remove(orphan)
+			break
+		}
+	}
+
+	orphan := &orphanBlock{block, now.Add(orphanExpiry)}
+	prevHash := hex.EncodeToString(block.PrevBlockHash)
+	p.orphans[hash] = orphan
+	p.byPrev[prevHash] = append(p.byPrev[prevHash], orphan)
+}
+
+// Has reports whether the block is in the pool
+func (p *OrphanPool) Has(hash []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.orphans[hex.EncodeToString(hash)]
+	return ok
+}
+
+// Count returns the number of orphans in the pool
+func (p *OrphanPool) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.orphans)
+}
+
+// MissingAncestor follows the orphans from the given block back to the
+// first one whose parent is not in the pool, and returns that parent's hash
+func (p *OrphanPool) MissingAncestor(hash []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	orphan, ok := p.orphans[hex.EncodeToString(hash)]
+	if !ok {
+		return hash
+	}
+
+	for {
+		parent, ok := p.orphans[hex.EncodeToString(orphan.block.PrevBlockHash)]
+		if !ok {
+			return orphan.block.PrevBlockHash
+		}
+		orphan = parent
+	}
+}
+
+// TakeChildren removes the orphans that build on the given block from the
+// pool and returns them
+func (p *OrphanPool) TakeChildren(parentHash []byte) []*Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var blocks []*Block
+	for _, orphan := range p.byPrev[hex.EncodeToString(parentHash)] {
+		blocks = append(blocks, orphan.block)
+		delete(p.orphans, hex.EncodeToString(orphan.block.Hash))
+	}
+	delete(p.byPrev, hex.EncodeToString(parentHash))
+
+	return blocks
+}
+
+func (p *OrphanPool) remove(orphan *orphanBlock) {
+	delete(p.orphans, hex.EncodeToString(orphan.block.Hash))
+
+	prevHash := hex.EncodeToString(orphan.block.PrevBlockHash)
+	siblings := p.byPrev[prevHash]
+	for i, sibling := range siblings {
+		if sibling == orphan {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+
+	if len(siblings) == 0 {
+		delete(p.byPrev, prevHash)
+	} else {
+		p.byPrev[prevHash] = siblings
+	}
+}
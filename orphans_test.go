@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newOrphan(hash, prevHash string) *Block {
+	return &Block{BlockHeader: BlockHeader{PrevBlockHash: []byte(prevHash)}, Hash: []byte(hash)}
+}
+
+func TestOrphanPool(t *testing.T) {
+	pool := NewOrphanPool()
+	pool.Add(newOrphan("b2", "b1"))
+	pool.Add(newOrphan("b3", "b2"))
+	pool.Add(newOrphan("c3", "b2"))
+	pool.Add(newOrphan("b3", "b2"))
+
+	assert.Equal(t, 3, pool.Count(), "Duplicates are stored once")
+	assert.True(t, pool.Has([]byte("b3")))
+	assert.Equal(t, []byte("b1"), pool.MissingAncestor([]byte("b3")), "The oldest missing parent is requested")
+
+	children := pool.TakeChildren([]byte("b1"))
+	assert.Len(t, children, 1)
+	assert.Equal(t, []byte("b2"), children[0].Hash)
+	assert.False(t, pool.Has([]byte("b2")))
+	assert.Len(t, pool.TakeChildren([]byte("b2")), 2, "Both children of b2 are released")
+	assert.Equal(t, 0, pool.Count())
+}
+
+func TestOrphanPoolEviction(t *testing.T) {
+	pool := NewOrphanPool()
+	for i := 0; i < maxOrphanBlocks+10; i++ {
+		pool.Add(newOrphan(string(rune(i+1000)), "missing"))
+	}
+	assert.Equal(t, maxOrphanBlocks, pool.Count(), "The pool is bounded")
+
+	for _, orphan := range pool.orphans {
+		orphan.expiration = time.Now().Add(-time.Second)
+	}
+	pool.Add(newOrphan("fresh", "other"))
+	assert.Equal(t, 1, pool.Count(), "Expired orphans are dropped")
+	assert.True(t, pool.Has([]byte("fresh")))
+}
@@ -11,10 +11,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-const (
-	peerDBPath = "peers_%s"
-	peerDBFile = "MANIFEST"
-)
+const peerDBFile = "MANIFEST"
 
 type Peers struct {
 	Database *badger.DB
@@ -29,7 +26,7 @@ func peerDBexist(path string) bool {
 
 // 创建Peers
 func getPeerDB(nodeId string) (*Peers, error) {
-	path := fmt.Sprintf(peerDBPath, nodeId)
+	path := fmt.Sprintf(activeNetParams.PeerDBPath, nodeId)
 	// 通过文件名打开DB
 	opts := badger.DefaultOptions(path)
 	// 忽略log
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// extraNonce2Size is the number of extra nonce bytes a pool worker chooses
+// itself, after the extra nonce the pool assigned to it
+const extraNonce2Size = 4
+
+// shareDifficultyShift makes the default share target 2^shareDifficultyShift
+// times easier than the network target
+const shareDifficultyShift = 8
+
+// jobRefreshInterval is how often the pool sends a new job, picking up new
+// mempool transactions and contributions
+const jobRefreshInterval = 30 * time.Second
+
+// poolAddress is the address the mining pool listens on, if set
+var poolAddress string
+
+// WorkSource is the node a pool gets its block templates from and submits
+// solved blocks to
+type WorkSource interface {
+	GetBlockTemplate() *BlockTemplate
+	SubmitBlock(block *Block) error
+	TipChanged() <-chan struct{}
+}
+
+// SubmitBlock adds a block solved by a miner and announces it
+func (bc *BlockChain) SubmitBlock(block *Block) error {
+	err := bc.AddBlock(block)
+	if err != nil {
+		return err
+	}
+
+	relayMinedBlock(block)
+
+	return nil
+}
+
+// PoolJob is a unit of work sent to pool workers. Workers put their extra
+// nonces into the coinbase input, then search for a header below the share
+// target.
+type PoolJob struct {
+	ID        string         `json:"jobid"`
+	Template  *BlockTemplate `json:"template"`
+	Coinbase  string         `json:"coinbase"`
+	ShareBits string         `json:"sharebits"`
+	Clean     bool           `json:"clean"`
+
+	// payouts are the contributions the coinbase pays, as they stood when
+	// the job was created
+	payouts map[string]*big.Int
+}
+
+// poolSubscription is the reply to a worker's subscribe request
+type poolSubscription struct {
+	ExtraNonce1     string `json:"extranonce1"`
+	ExtraNonce2Size int    `json:"extranonce2size"`
+}
+
+type poolWorker struct {
+	conn        net.Conn
+	extraNonce1 []byte
+	address     string
+	mu          sync.Mutex
+}
+
+// Pool hands out work derived from the block templates of a WorkSource to
+// TCP workers, credits their shares and pays them proportionally in the
+// coinbase of the blocks it finds
+type Pool struct {
+	source    WorkSource
+	operator  string
+	shareBits uint32
+
+	mu            sync.Mutex
+	workers       map[*poolWorker]bool
+	jobs          map[string]*PoolJob
+	currentJob    *PoolJob
+	jobCounter    int
+	nextExtra     uint32
+	shares        map[string]bool
+	contributions map[string]*big.Int
+}
+
+// NewPool creates a pool. The operator address receives the rewards that are
+// not paid to workers. A shareBits of 0 selects a share target
+// 2^shareDifficultyShift times easier than the network target.
+func NewPool(source WorkSource, operator string, shareBits uint32) *Pool {
+	return &Pool{
+		source:        source,
+		operator:      operator,
+		shareBits:     shareBits,
+		workers:       make(map[*poolWorker]bool),
+		jobs:          make(map[string]*PoolJob),
+		shares:        make(map[string]bool),
+		contributions: make(map[string]*big.Int),
+	}
+}
+
+// StartPool runs a pool for the chain on the address
+func StartPool(address string, bc *BlockChain, operator string) {
+	if !isProofOfWork(activeConsensus) {
+		log.Panicf("ERROR: A mining pool needs proof-of-work, not %s", activeConsensus.Name())
+	}
+
+	ln, err := net.Listen(protocol, address)
+	if err != nil {
+		log.Panic(err)
+	}
+	log.Printf("Mining pool listening on %s\n", address)
+
+	NewPool(bc, operator, 0).Serve(ln)
+}
+
+// Serve accepts workers on the listener until it is closed
+func (p *Pool) Serve(ln net.Listener) {
+	p.refreshJob()
+	go p.refreshJobs()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		go p.handleWorker(conn)
+	}
+}
+
+// refreshJobs sends a new job when the tip changes or the current job has
+// been out for jobRefreshInterval
+func (p *Pool) refreshJobs() {
+	ticker := time.NewTicker(jobRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.source.TipChanged():
+		case <-ticker.C:
+		}
+
+		p.refreshJob()
+	}
+}
+
+// refreshJob builds a job from a fresh block template, with a coinbase that
+// splits the reward between the workers by their contribution, and sends it
+// to every worker
+func (p *Pool) refreshJob() {
+	template := p.source.GetBlockTemplate()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clean := p.currentJob == nil || p.currentJob.Template.PrevBlockHash != template.PrevBlockHash
+	if clean {
+		p.jobs = make(map[string]*PoolJob)
+		p.shares = make(map[string]bool)
+	}
+
+	shareBits := p.shareBits
+	if shareBits == 0 {
+		target := CompactToBig(mustParseBits(template.Bits))
+		shareBits = BigToCompact(target.Lsh(target, shareDifficultyShift))
+	}
+
+	payouts := make(map[string]*big.Int)
+	for address, work := range p.contributions {
+		payouts[address] = new(big.Int).Set(work)
+	}
+
+	p.jobCounter++
+	job := &PoolJob{
+		ID:        strconv.Itoa(p.jobCounter),
+		Template:  template,
+		Coinbase:  hex.EncodeToString(p.payoutCoinbase(template.CoinbaseValue, payouts).Serialize()),
+		ShareBits: fmt.Sprintf("%08x", shareBits),
+		Clean:     clean,
+		payouts:   payouts,
+	}
+	p.jobs[job.ID] = job
+	p.currentJob = job
+
+	for worker := range p.workers {
+		if worker.address != "" {
+			go worker.notify(job)
+		}
+	}
+}
+
+// payoutCoinbase creates the coinbase of a job with its extra nonces left
+// empty. Every worker is paid in proportion to the work of its shares in the
+// payouts; the rest goes to the operator.
+func (p *Pool) payoutCoinbase(value int, payouts map[string]*big.Int) *Transaction {
+	var addresses []string
+	totalWork := new(big.Int)
+	for address, work := range payouts {
+		addresses = append(addresses, address)
+		totalWork.Add(totalWork, work)
+	}
+	sort.Strings(addresses)
+
+	var outputs []TXOutput
+	paid := 0
+	for _, address := range addresses {
+		amount := new(big.Int).Mul(big.NewInt(int64(value)), payouts[address])
+		amount.Div(amount, totalWork)
+		if amount.Sign() > 0 {
+			outputs = append(outputs, *NewTXOutput(int(amount.Int64()), address))
+			paid += int(amount.Int64())
+		}
+	}
+	if value-paid > 0 || len(outputs) == 0 {
+		outputs = append(outputs, *NewTXOutput(value-paid, p.operator))
+	}
+
+	txin := TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte{}}
+	coinbase := Transaction{nil, []TXInput{txin}, outputs, 0}
+	coinbase.ID = coinbase.Hash()
+
+	return &coinbase
+}
+
+// handleWorker serves the requests of a connected worker
+func (p *Pool) handleWorker(conn net.Conn) {
+	defer conn.Close()
+
+	p.mu.Lock()
+	extraNonce1 := make([]byte, 4)
+	binary.BigEndian.PutUint32(extraNonce1, p.nextExtra)
+	p.nextExtra++
+	worker := &poolWorker{conn: conn, extraNonce1: extraNonce1}
+	p.workers[worker] = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.workers, worker)
+		p.mu.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var request rpcRequest
+		err := json.Unmarshal(scanner.Bytes(), &request)
+		if err != nil {
+			log.Printf("Invalid request from pool worker %s: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+
+		response := rpcResponse{ID: request.ID}
+		result, err := p.handleRequest(worker, request)
+		if err != nil {
+			message := err.Error()
+			response.Error = &message
+		} else {
+			response.Result = result
+		}
+		worker.send(response)
+
+		if request.Method == "authorize" && err == nil {
+			p.mu.Lock()
+			job := p.currentJob
+			p.mu.Unlock()
+			worker.notify(job)
+		}
+	}
+}
+
+func (p *Pool) handleRequest(worker *poolWorker, request rpcRequest) (interface{}, error) {
+	switch request.Method {
+	case "subscribe":
+		return poolSubscription{hex.EncodeToString(worker.extraNonce1), extraNonce2Size}, nil
+	case "authorize":
+		var address string
+		if len(request.Params) != 1 || json.Unmarshal(request.Params[0], &address) != nil {
+			return nil, fmt.Errorf("authorize takes the payout address")
+		}
+		if !ValidateAddress(address) {
+			return nil, fmt.Errorf("invalid payout address %s", address)
+		}
+
+		p.mu.Lock()
+		worker.address = address
+		p.mu.Unlock()
+
+		return true, nil
+	case "submit":
+		var jobID, extraNonce2 string
+		var timestamp int64
+		var nonce uint32
+		if len(request.Params) != 4 ||
+			json.Unmarshal(request.Params[0], &jobID) != nil ||
+			json.Unmarshal(request.Params[1], &extraNonce2) != nil ||
+			json.Unmarshal(request.Params[2], &timestamp) != nil ||
+			json.Unmarshal(request.Params[3], &nonce) != nil {
+			return nil, fmt.Errorf("submit takes the job ID, extra nonce 2, timestamp and nonce")
+		}
+
+		return true, p.submitShare(worker, jobID, extraNonce2, timestamp, nonce)
+	default:
+		return nil, fmt.Errorf("unknown method %q", request.Method)
+	}
+}
+
+// submitShare checks a share, credits the worker and submits the block to
+// the work source when the share also meets the network target
+func (p *Pool) submitShare(worker *poolWorker, jobID, extraNonce2Hex string, timestamp int64, nonce uint32) error {
+	p.mu.Lock()
+	job := p.jobs[jobID]
+	address := worker.address
+	p.mu.Unlock()
+
+	if address == "" {
+		return fmt.Errorf("worker is not authorized")
+	}
+	if job == nil {
+		return fmt.Errorf("job %s is stale", jobID)
+	}
+
+	extraNonce2, err := hex.DecodeString(extraNonce2Hex)
+	if err != nil || len(extraNonce2) != extraNonce2Size {
+		return fmt.Errorf("extra nonce 2 must be %d bytes", extraNonce2Size)
+	}
+	if timestamp < job.Template.MinTime || timestamp > timeSource.AdjustedTime()+maxFutureBlockTime {
+		return fmt.Errorf("timestamp %d is out of range", timestamp)
+	}
+
+	block, err := job.Block(worker.extraNonce1, extraNonce2)
+	if err != nil {
+		return err
+	}
+	block.Timestamp = timestamp
+	block.Nonce = nonce
+	block.Hash = block.BlockHeader.Hash()
+
+	hashInt := new(big.Int).SetBytes(block.Hash)
+	networkTarget := CompactToBig(block.Bits)
+	shareTarget := CompactToBig(mustParseBits(job.ShareBits))
+	if shareTarget.Cmp(networkTarget) < 0 {
+		shareTarget = networkTarget
+	}
+	if hashInt.Cmp(shareTarget) >= 0 {
+		return fmt.Errorf("share does not meet the share target")
+	}
+
+	p.mu.Lock()
+	if p.shares[hex.EncodeToString(block.Hash)] {
+		p.mu.Unlock()
+		return fmt.Errorf("duplicate share")
+	}
+	p.shares[hex.EncodeToString(block.Hash)] = true
+
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	work.Div(work, new(big.Int).Add(shareTarget, big.NewInt(1)))
+	if p.contributions[address] == nil {
+		p.contributions[address] = new(big.Int)
+	}
+	p.contributions[address].Add(p.contributions[address], work)
+	p.mu.Unlock()
+
+	if hashInt.Cmp(networkTarget) >= 0 {
+		return nil
+	}
+
+	err = p.source.SubmitBlock(block)
+	if err != nil {
+		log.Printf("Pool block %x was rejected: %s\n", block.Hash, err)
+		return nil
+	}
+	log.Printf("Pool found block %x at height %d\n", block.Hash, block.Height)
+
+	// The coinbase paid the contributions of the job. Shares credited since
+	// the job was created are left for the next block.
+	p.mu.Lock()
+	for address, work := range job.payouts {
+		if contribution := p.contributions[address]; contribution != nil {
+			contribution.Sub(contribution, work)
+			if contribution.Sign() <= 0 {
+				delete(p.contributions, address)
+			}
+		}
+	}
+	job.payouts = nil // paid once, even if the job solves another block
+	p.mu.Unlock()
+	p.refreshJob()
+
+	return nil
+}
+
+// Block builds the unsolved block of the job with the given extra nonces
+func (job *PoolJob) Block(extraNonce1, extraNonce2 []byte) (*Block, error) {
+	data, err := hex.DecodeString(job.Coinbase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid coinbase in job %s", job.ID)
+	}
+
+	coinbase, err := decodeTransaction(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid coinbase in job %s: %s", job.ID, err)
+	}
+	coinbase.Vin[0].PubKey = append(append([]byte{}, extraNonce1...), extraNonce2...)
+	coinbase.ID = coinbase.Hash()
+
+	return job.Template.NewBlock(&coinbase)
+}
+
+func (w *poolWorker) notify(job *PoolJob) {
+	params, err := json.Marshal(job)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	w.send(rpcRequest{Method: "notify", Params: []json.RawMessage{params}})
+}
+
+func (w *poolWorker) send(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.conn.Write(append(data, '\n'))
+	if err != nil {
+		log.Printf("Cannot send to pool worker %s: %s\n", w.conn.RemoteAddr(), err)
+	}
+}
+
+func mustParseBits(bits string) uint32 {
+	parsed, err := strconv.ParseUint(bits, 16, 32)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return uint32(parsed)
+}
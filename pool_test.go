@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testWorkSource serves a fixed template and records submitted blocks
+type testWorkSource struct {
+	mu        sync.Mutex
+	bits      string
+	submitted []*Block
+}
+
+func (s *testWorkSource) GetBlockTemplate() *BlockTemplate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &BlockTemplate{
+		Version:       blockVersion,
+		PrevBlockHash: "0000000000000000000000000000000000000000000000000000000000000001",
+		Height:        1,
+		CurTime:       time.Now().Unix(),
+		MinTime:       time.Now().Unix() - 60,
+		Bits:          s.bits,
+		CoinbaseValue: 30,
+	}
+}
+
+func (s *testWorkSource) SubmitBlock(block *Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.submitted = append(s.submitted, block)
+	return nil
+}
+
+func (s *testWorkSource) TipChanged() <-chan struct{} {
+	return nil
+}
+
+type testPoolWorker struct {
+	t           *testing.T
+	conn        net.Conn
+	scanner     *bufio.Scanner
+	extraNonce1 []byte
+	job         *PoolJob
+}
+
+func dialTestPool(t *testing.T, addr, payout string) *testPoolWorker {
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	w := &testPoolWorker{t: t, conn: conn, scanner: bufio.NewScanner(conn)}
+	w.scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var subscription poolSubscription
+	assert.Nil(t, w.call("subscribe", &subscription))
+	w.extraNonce1, _ = hex.DecodeString(subscription.ExtraNonce1)
+	assert.Nil(t, w.call("authorize", nil, payout))
+	w.readJob()
+
+	return w
+}
+
+func (w *testPoolWorker) call(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"id": 1, "method": method, "params": params})
+	_, err := w.conn.Write(append(data, '\n'))
+	assert.Nil(w.t, err)
+
+	assert.True(w.t, w.scanner.Scan())
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *string         `json:"error"`
+	}
+	assert.Nil(w.t, json.Unmarshal(w.scanner.Bytes(), &response))
+	if response.Error != nil {
+		return &RuleError{Description: *response.Error}
+	}
+	if result != nil {
+		assert.Nil(w.t, json.Unmarshal(response.Result, result))
+	}
+
+	return nil
+}
+
+func (w *testPoolWorker) readJob() {
+	assert.True(w.t, w.scanner.Scan())
+	var notification rpcRequest
+	assert.Nil(w.t, json.Unmarshal(w.scanner.Bytes(), &notification))
+	assert.Equal(w.t, "notify", notification.Method)
+
+	var job PoolJob
+	assert.Nil(w.t, json.Unmarshal(notification.Params[0], &job))
+	w.job = &job
+}
+
+// solveShare finds a header below the target for the extra nonce 2 and
+// submits it
+func (w *testPoolWorker) solveShare(extraNonce2 []byte, target *big.Int) error {
+	block, err := w.job.Block(w.extraNonce1, extraNonce2)
+	assert.Nil(w.t, err)
+
+	for nonce := uint32(0); ; nonce++ {
+		block.Nonce = nonce
+		hash := new(big.Int).SetBytes(block.BlockHeader.Hash())
+		if hash.Cmp(target) < 0 {
+			return w.call("submit", nil, w.job.ID, hex.EncodeToString(extraNonce2), block.Timestamp, nonce)
+		}
+	}
+}
+
+func TestPoolSharesAndPayouts(t *testing.T) {
+	source := &testWorkSource{bits: "1d00ffff"}
+	pool := NewPool(source, string(NewWallet().GetAddress()), 0x207fffff)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go pool.Serve(ln)
+
+	alice := string(NewWallet().GetAddress())
+	bob := string(NewWallet().GetAddress())
+	aliceWorker := dialTestPool(t, ln.Addr().String(), alice)
+	bobWorker := dialTestPool(t, ln.Addr().String(), bob)
+	assert.NotEqual(t, aliceWorker.extraNonce1, bobWorker.extraNonce1, "Workers get their own extra nonce range")
+
+	shareTarget := CompactToBig(0x207fffff)
+	assert.Nil(t, aliceWorker.solveShare([]byte{0, 0, 0, 1}, shareTarget))
+	assert.Nil(t, aliceWorker.solveShare([]byte{0, 0, 0, 2}, shareTarget))
+	assert.Nil(t, bobWorker.solveShare([]byte{0, 0, 0, 1}, shareTarget))
+	assert.NotNil(t, bobWorker.solveShare([]byte{0, 0, 0, 1}, shareTarget), "Duplicate shares are rejected")
+	assert.NotNil(t, bobWorker.call("submit", nil, "99", "00000001", time.Now().Unix(), 0), "Unknown jobs are rejected")
+	assert.Empty(t, source.submitted, "Shares below the network target are not blocks")
+
+	// The next job pays the workers for their shares, and its block is easy
+	source.mu.Lock()
+	source.bits = "207fffff"
+	source.mu.Unlock()
+	pool.refreshJob()
+	aliceWorker.readJob()
+	bobWorker.readJob()
+
+	assert.Nil(t, bobWorker.solveShare([]byte{0, 0, 0, 9}, CompactToBig(0x207fffff)))
+	assert.Len(t, source.submitted, 1)
+
+	block := source.submitted[0]
+	hash := new(big.Int).SetBytes(block.BlockHeader.Hash())
+	assert.Equal(t, -1, hash.Cmp(CompactToBig(0x207fffff)), "The block meets the network target")
+	payouts := make(map[string]int)
+	for _, out := range block.Transactions[0].Vout {
+		for _, address := range []string{alice, bob} {
+			if out.IsLockedWithKey(addressPubKeyHash(address)) {
+				payouts[address] = out.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int{alice: 20, bob: 10}, payouts, "Rewards follow the contributions")
+}
+
+func addressPubKeyHash(address string) []byte {
+	pubKeyHash := Base58Decode([]byte(address))
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
+
+func TestPoolPaysSharesAfterJobSnapshot(t *testing.T) {
+	source := &testWorkSource{bits: "1d00ffff"}
+	pool := NewPool(source, string(NewWallet().GetAddress()), 0x207fffff)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go pool.Serve(ln)
+
+	alice := string(NewWallet().GetAddress())
+	bob := string(NewWallet().GetAddress())
+	aliceWorker := dialTestPool(t, ln.Addr().String(), alice)
+	bobWorker := dialTestPool(t, ln.Addr().String(), bob)
+	shareTarget := CompactToBig(0x207fffff)
+	assert.Nil(t, aliceWorker.solveShare([]byte{0, 0, 0, 1}, shareTarget))
+
+	// The next job pays Alice's share, and its block is easy
+	source.mu.Lock()
+	source.bits = "207fffff"
+	source.mu.Unlock()
+	hardJob := bobWorker.job
+	pool.refreshJob()
+	aliceWorker.readJob()
+	bobWorker.readJob()
+
+	// Bob's share on the earlier job comes after the snapshot of the next
+	easyJob := bobWorker.job
+	bobWorker.job = hardJob
+	assert.Nil(t, bobWorker.solveShare([]byte{0, 0, 0, 1}, shareTarget))
+	bobWorker.job = easyJob
+	assert.Empty(t, source.submitted)
+
+	assert.Nil(t, aliceWorker.solveShare([]byte{0, 0, 0, 2}, shareTarget))
+	assert.Len(t, source.submitted, 1)
+
+	payouts := func(coinbase *Transaction) map[string]int {
+		payouts := make(map[string]int)
+		for _, out := range coinbase.Vout {
+			for _, address := range []string{alice, bob} {
+				if out.IsLockedWithKey(addressPubKeyHash(address)) {
+					payouts[address] = out.Value
+				}
+			}
+		}
+		return payouts
+	}
+	assert.Equal(t, map[string]int{alice: 30}, payouts(source.submitted[0].Transactions[0]), "The block pays the snapshot")
+
+	// Bob's share and Alice's winning share are paid by the next job
+	aliceWorker.readJob()
+	block, err := aliceWorker.job.Block(aliceWorker.extraNonce1, []byte{0, 0, 0, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{alice: 15, bob: 15}, payouts(block.Transactions[0]), "Shares after the snapshot are carried over")
+}
@@ -1,20 +1,31 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
-	"fmt"
+	"encoding/binary"
+	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
-	maxNonce = math.MaxInt64
+	maxNonce = math.MaxUint32
 )
 
-// ! 在比特币中，当一个块被挖出来以后，“target bits” 代表了区块头里存储的难度，也就是开头有多少个 0。
-// ! 这里的 24 指的是算出来的哈希前 24 位必须是 0，如果用 16 进制表示，就是前 6 位必须是 0
-const targetBits = 16
+// cancelCheckInterval is the number of nonces a mining goroutine tries
+// between checks for cancellation
+const cancelCheckInterval = 1 << 14
+
+// hashrateInterval is how often the miner reports its hashrate
+const hashrateInterval = 5 * time.Second
+
+// miningThreads is the number of goroutines MineBlock searches with
+var miningThreads = runtime.NumCPU()
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
@@ -24,68 +35,158 @@ type ProofOfWork struct {
 
 // !NewProofOfWork builds and returns a ProofOfWork
 // !
-// !target.Lsh(1, 8)
-// !fmt.Printf("%b\n", target)
-// !
-// !100000000
+// ! 在比特币中，当一个块被挖出来以后，“target bits” 代表了区块头里存储的难度。
+// ! 目标值由区块的 Bits 字段（compact 格式）还原
 func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target := CompactToBig(b.Bits)
 
 	pow := &ProofOfWork{b, target}
 
 	return pow
 }
 
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+// Run performs a proof-of-work on a single goroutine. Nonces are tried in
+// order, so the same header always gets the same solution.
+func (pow *ProofOfWork) Run() (uint32, []byte) {
+	nonce, hash, err := pow.RunParallel(context.Background(), 1)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return nonce, hash
 }
 
-// Run performs a proof-of-work
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
+// RunParallel performs a proof-of-work with the nonce space split between
+// threads goroutines. The header is serialized once and only the nonce
+// bytes are rewritten on every attempt. When the whole nonce space runs out
+// the block's timestamp is bumped and the search starts over. The search
+// stops with the context's error when the context is cancelled.
+func (pow *ProofOfWork) RunParallel(ctx context.Context, threads int) (uint32, []byte, error) {
+	if threads < 1 {
+		threads = 1
+	}
 
-	fmt.Printf("Mining a new block")
-	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
+	var hashes uint64
+	start := time.Now()
+	report := time.NewTicker(hashrateInterval)
+	defer report.Stop()
+
+	for {
+		data := pow.block.BlockHeader.Serialize()
+		found := make(chan powSolution, threads)
+		searchCtx, cancel := context.WithCancel(ctx)
+
+		var wg sync.WaitGroup
+		chunk := (uint64(maxNonce) + 1) / uint64(threads)
+		for i := 0; i < threads; i++ {
+			first := uint64(i) * chunk
+			last := first + chunk - 1
+			if i == threads-1 {
+				last = uint64(maxNonce)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pow.search(searchCtx, data, first, last, &hashes, found)
+			}()
+		}
 
-		hash = sha256.Sum256(data)
-		if math.Remainder(float64(nonce), 100000) == 0 {
-			fmt.Printf("\r%x", hash)
+		exhausted := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(exhausted)
+		}()
+
+	Wait:
+		for {
+			select {
+			case solution := <-found:
+				cancel()
+				<-exhausted
+
+				return solution.nonce, solution.hash, nil
+			case <-exhausted:
+				// A solution may have been sent right before the last
+				// goroutine finished
+				select {
+				case solution := <-found:
+					cancel()
+					return solution.nonce, solution.hash, nil
+				default:
+				}
+				break Wait
+			case <-ctx.Done():
+				cancel()
+				<-exhausted
+
+				return 0, nil, ctx.Err()
+			case <-report.C:
+				elapsed := time.Since(start).Seconds()
+				log.Printf("Mining block %d: %.1f kH/s\n", pow.block.Height, float64(atomic.LoadUint64(&hashes))/elapsed/1000)
+			}
 		}
+
+		cancel()
+		pow.block.Timestamp++
+	}
+}
+
+type powSolution struct {
+	nonce uint32
+	hash  []byte
+}
+
+// search tries the nonces from first to last on its own copy of the header
+// and sends the first solution it finds
+func (pow *ProofOfWork) search(ctx context.Context, header []byte, first, last uint64, hashes *uint64, found chan<- powSolution) {
+	var hashInt big.Int
+	data := make([]byte, len(header))
+	copy(data, header)
+
+	for nonce := first; nonce <= last; nonce++ {
+		if (nonce-first)%cancelCheckInterval == cancelCheckInterval-1 {
+			atomic.AddUint64(hashes, cancelCheckInterval)
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+
+		binary.BigEndian.PutUint32(data[nonceOffset:], uint32(nonce))
+		hash := sha256.Sum256(data)
 		hashInt.SetBytes(hash[:])
 
 		if hashInt.Cmp(pow.target) == -1 {
-			break
-		} else {
-			nonce++
+			atomic.AddUint64(hashes, (nonce-first)%cancelCheckInterval+1)
+			found <- powSolution{uint32(nonce), hash[:]}
+			return
 		}
 	}
-	fmt.Print("\n\n")
+}
+
+// Work returns the expected number of hashes needed to find a block below
+// the target. It is used to compare the cumulative work of competing chains.
+func (pow *ProofOfWork) Work() *big.Int {
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+	denominator := new(big.Int).Add(pow.target, big.NewInt(1))
 
-	return nonce, hash[:]
+	return numerator.Div(numerator, denominator)
 }
 
-// Validate validates block's PoW
-func (pow *ProofOfWork) Validate() bool {
+// Validate validates block's PoW. The block's bits must match the difficulty
+// the chain requires at its height.
+func (pow *ProofOfWork) Validate(requiredBits uint32) bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	if pow.block.Bits != requiredBits || pow.target.Cmp(activeNetParams.PowLimit) > 0 {
+		return false
+	}
+
+	hash := pow.block.BlockHeader.Hash()
+	hashInt.SetBytes(hash)
 
 	isValid := hashInt.Cmp(pow.target) == -1
 
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunParallel(t *testing.T) {
+	block := newBlockTemplate([]*Transaction{NewCoinbaseTX("miner", "", 1, 0)}, make([]byte, 32), 1, activeNetParams.GenesisBits, time.Now().Unix())
+
+	assert.Nil(t, ProofOfWorkEngine{}.Seal(context.Background(), nil, block, 4))
+	assert.Equal(t, block.BlockHeader.Hash(), block.Hash)
+	assert.Nil(t, ProofOfWorkEngine{}.VerifySeal(block), "The nonce found by one of the goroutines meets the target")
+}
+
+func TestRunParallelBumpsTimestamp(t *testing.T) {
+	defer func(n int) { maxNonce = n }(maxNonce)
+	maxNonce = 3
+
+	// One in 256 hashes meets the target
+	block := newBlockTemplate([]*Transaction{NewCoinbaseTX("miner", "", 1, 0)}, make([]byte, 32), 1, 0x20010000, 1700000000)
+	target := CompactToBig(block.Bits)
+	hasSolution := func() bool {
+		for nonce := 0; nonce <= maxNonce; nonce++ {
+			block.Nonce = uint32(nonce)
+			if new(big.Int).SetBytes(block.BlockHeader.Hash()).Cmp(target) < 0 {
+				return true
+			}
+		}
+
+		return false
+	}
+	for hasSolution() {
+		block.Timestamp++
+	}
+	start := block.Timestamp
+
+	nonce, hash, err := NewProofOfWork(block).RunParallel(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Greater(t, block.Timestamp, start, "The timestamp is bumped once the nonces run out")
+	block.Nonce = nonce
+	assert.Equal(t, block.BlockHeader.Hash(), hash)
+	assert.Equal(t, -1, new(big.Int).SetBytes(hash).Cmp(target))
+}
+
+func TestSealCancelled(t *testing.T) {
+	// No hash meets a target of 1
+	block := newBlockTemplate([]*Transaction{NewCoinbaseTX("miner", "", 1, 0)}, make([]byte, 32), 1, 0x03000001, time.Now().Unix())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	assert.Equal(t, context.Canceled, ProofOfWorkEngine{}.Seal(ctx, nil, block, 2))
+}
+
+// impossiblePoW asks for a target no hash meets
+type impossiblePoW struct {
+	ProofOfWorkEngine
+}
+
+func (impossiblePoW) RequiredBits(tx *bolt.Tx, parent *Block) uint32 {
+	return 0x03000001
+}
+
+func TestMineBlockCancelledOnTipChange(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc := newTestBlockChain(t, address)
+	tip := bc.tip
+	activeConsensus = impossiblePoW{}
+	defer func() { activeConsensus = ProofOfWorkEngine{} }()
+
+	done := make(chan error)
+	go func() {
+		_, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(address, "", 1, 0)})
+		done <- err
+	}()
+
+	// Wait until the miner watches the tip
+	for {
+		bc.tipMu.Lock()
+		watching := bc.tipChanged != nil
+		bc.tipMu.Unlock()
+		if watching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	bc.setTip(tip)
+
+	assert.Equal(t, ErrTipChanged, <-done)
+}
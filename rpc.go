@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// rpcAddress is the address the JSON-RPC server listens on, if set
+var rpcAddress string
+
+type rpcRequest struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  *string     `json:"error"`
+}
+
+// rpcServer answers JSON-RPC requests from miners
+type rpcServer struct {
+	bc *BlockChain
+}
+
+// StartRPCServer serves the getblocktemplate and submitblock JSON-RPC
+// methods over HTTP
+func StartRPCServer(address string, bc *BlockChain) {
+	if !isProofOfWork(activeConsensus) {
+		log.Panicf("ERROR: Block templates are only served under proof-of-work, not %s", activeConsensus.Name())
+	}
+	log.Printf("JSON-RPC server listening on %s\n", address)
+
+	err := http.ListenAndServe(address, &rpcServer{bc})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request rpcRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := rpcResponse{ID: request.ID}
+	result, err := s.handle(request)
+	if err != nil {
+		message := err.Error()
+		response.Error = &message
+	} else {
+		response.Result = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *rpcServer) handle(request rpcRequest) (interface{}, error) {
+	switch request.Method {
+	case "getblocktemplate":
+		return s.bc.GetBlockTemplate(), nil
+	case "submitblock":
+		if len(request.Params) != 1 {
+			return nil, fmt.Errorf("submitblock takes the hex encoded block")
+		}
+
+		var blockHex string
+		err := json.Unmarshal(request.Params[0], &blockHex)
+		if err != nil {
+			return nil, fmt.Errorf("submitblock takes the hex encoded block")
+		}
+
+		return nil, s.submitBlock(blockHex)
+	default:
+		return nil, fmt.Errorf("unknown method %q", request.Method)
+	}
+}
+
+// submitBlock validates and connects a block solved by a miner
+func (s *rpcServer) submitBlock(blockHex string) error {
+	data, err := hex.DecodeString(blockHex)
+	if err != nil {
+		return fmt.Errorf("block is not hex encoded")
+	}
+
+	block, err := decodeBlock(data)
+	if err != nil {
+		return err
+	}
+
+	err = s.bc.SubmitBlock(block)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Miner submitted block %x\n", block.Hash)
+
+	return nil
+}
+
+// rpcCall calls a JSON-RPC method of the node at url and decodes its result
+func rpcCall(url, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", method, resp.Status)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *string         `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s", method, *response.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
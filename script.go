@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Opcodes of the script language. Values follow Bitcoin's script so that
+// familiar tooling can read the scripts, but only the opcodes below exist.
+const (
+	Op0         = 0x00
+	OpPushData1 = 0x4c
+	OpPushData2 = 0x4d
+	Op1Negate   = 0x4f
+	Op1         = 0x51
+	Op16        = 0x60
+
+	OpNop    = 0x61
+	OpIf     = 0x63
+	OpNotIf  = 0x64
+	OpElse   = 0x67
+	OpEndIf  = 0x68
+	OpVerify = 0x69
+	OpReturn = 0x6a
+
+	OpDrop = 0x75
+	OpDup  = 0x76
+	OpSwap = 0x7c
+	OpSize = 0x82
+
+	OpEqual       = 0x87
+	OpEqualVerify = 0x88
+
+	OpSHA256              = 0xa8
+	OpHash160             = 0xa9
+	OpHash256             = 0xaa
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckMultiSigVerify = 0xaf
+
+	OpCheckLockTimeVerify = 0xb1
+	OpCheckSequenceVerify = 0xb2
+)
+
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	Op1Negate:             "OP_1NEGATE",
+	OpNop:                 "OP_NOP",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpSwap:                "OP_SWAP",
+	OpSize:                "OP_SIZE",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSHA256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpHash256:             "OP_HASH256",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+// Limits that keep the evaluation of a script cheap
+const (
+	maxScriptSize         = 10000
+	maxScriptElementSize  = 520
+	maxStackSize          = 1000
+	maxOpsPerScript       = 201
+	maxPubKeysPerMultiSig = 20
+	// maxScriptNumLen is the size of the numbers arithmetic works on, lock
+	// times may use one more byte
+	maxScriptNumLen = 4
+)
+
+// parsedOpcode is an opcode of a script with the data it pushes
+type parsedOpcode struct {
+	opcode byte
+	data   []byte
+}
+
+// parseScript splits a script into its opcodes
+func parseScript(script []byte) ([]parsedOpcode, error) {
+	var ops []parsedOpcode
+
+	for i := 0; i < len(script); {
+		opcode := script[i]
+		i++
+
+		var size int
+		switch {
+		case opcode > Op0 && opcode < OpPushData1:
+			size = int(opcode)
+		case opcode == OpPushData1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("OP_PUSHDATA1 at the end of the script has no length")
+			}
+			size = int(script[i])
+			i++
+		case opcode == OpPushData2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("OP_PUSHDATA2 at the end of the script has no length")
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			ops = append(ops, parsedOpcode{opcode, nil})
+			continue
+		}
+
+		if i+size > len(script) {
+			return nil, fmt.Errorf("script pushes %d bytes, only %d are left", size, len(script)-i)
+		}
+		ops = append(ops, parsedOpcode{opcode, script[i : i+size]})
+		i += size
+	}
+
+	return ops, nil
+}
+
+// isPushOnly reports whether the script only pushes data
+func isPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for _, op := range ops {
+		if op.opcode > Op16 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pushData appends the shortest opcode pushing data to the script
+func pushData(script, data []byte) []byte {
+	switch {
+	case len(data) == 0:
+		return append(script, Op0)
+	case len(data) < OpPushData1:
+		script = append(script, byte(len(data)))
+	case len(data) <= 0xff:
+		script = append(script, OpPushData1, byte(len(data)))
+	default:
+		script = append(script, OpPushData2, byte(len(data)), byte(len(data)>>8))
+	}
+
+	return append(script, data...)
+}
+
+// pushInt appends the shortest opcode pushing the number to the script
+func pushInt(script []byte, n int64) []byte {
+	switch {
+	case n == 0:
+		return append(script, Op0)
+	case n == -1:
+		return append(script, Op1Negate)
+	case n >= 1 && n <= 16:
+		return append(script, byte(Op1+n-1))
+	}
+
+	return pushData(script, scriptNumBytes(n))
+}
+
+// scriptNumBytes encodes a number the way scripts push it: little-endian
+// with the sign in the highest bit of the last byte, zero is empty
+func scriptNumBytes(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var result []byte
+	for n > 0 {
+		result = append(result, byte(n))
+		n >>= 8
+	}
+
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// scriptNum decodes a number pushed by a script, which may be at most maxLen
+// bytes long
+func scriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, fmt.Errorf("number %x is longer than %d bytes", data, maxLen)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << uint(8*i)
+	}
+
+	last := len(data) - 1
+	if data[last]&0x80 != 0 {
+		n &^= int64(0x80) << uint(8*last)
+		n = -n
+	}
+
+	return n, nil
+}
+
+// asBool interprets a stack element as a boolean. Zero and negative zero of
+// any length are false.
+func asBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return i != len(data)-1 || b != 0x80
+		}
+	}
+
+	return false
+}
+
+// opcodeName returns the name of the opcode as Bitcoin spells it
+func opcodeName(opcode byte) string {
+	if opcode >= Op1 && opcode <= Op16 {
+		return fmt.Sprintf("OP_%d", opcode-Op1+1)
+	}
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OP_UNKNOWN%#x", opcode)
+}
+
+// DisasmScript returns a human-readable form of the script
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+
+	var words []string
+	for _, op := range ops {
+		if op.opcode > Op0 && op.opcode <= OpPushData2 {
+			words = append(words, hex.EncodeToString(op.data))
+		} else {
+			words = append(words, opcodeName(op.opcode))
+		}
+	}
+
+	if err != nil {
+		words = append(words, "[error]")
+	}
+
+	return strings.Join(words, " ")
+}
+
+// VerifyScript checks that scriptSig unlocks scriptPubKey for the input at
+// inIndex of the transaction, which spends the output spent. scriptSig may
+// only push data. It runs first, then scriptPubKey runs on the stack it
+// leaves, and the input is unlocked when a single true element remains.
+// When scriptPubKey pays to a script hash, the last push of scriptSig is the
+// redeem script, which then runs on the other pushes.
+func VerifyScript(scriptSig, scriptPubKey []byte, tx *Transaction, inIndex int, spent TXOutput) error {
+	if !isPushOnly(scriptSig) {
+		return fmt.Errorf("signature script does not only push data")
+	}
+
+	vm := scriptEngine{tx: tx, inIndex: inIndex, spent: spent}
+
+	err := vm.execute(scriptSig)
+	if err != nil {
+		return err
+	}
+	pushes := append([][]byte{}, vm.stack...)
+
+	err = vm.execute(scriptPubKey)
+	if err != nil {
+		return err
+	}
+
+	if extractScriptHash(scriptPubKey) != nil {
+		if !vm.succeeded() {
+			return fmt.Errorf("redeem script does not match the script hash")
+		}
+
+		redeemScript := pushes[len(pushes)-1]
+		vm.stack = pushes[:len(pushes)-1]
+
+		err = vm.execute(redeemScript)
+		if err != nil {
+			return fmt.Errorf("redeem script: %s", err)
+		}
+	}
+
+	if !vm.succeeded() {
+		return fmt.Errorf("script evaluated to false")
+	}
+	if len(vm.stack) != 1 {
+		return fmt.Errorf("script left %d elements on the stack", len(vm.stack))
+	}
+
+	return nil
+}
+
+// scriptEngine evaluates the scripts of a transaction input
+type scriptEngine struct {
+	tx      *Transaction
+	inIndex int
+	spent   TXOutput
+
+	stack [][]byte
+	// conditions holds a flag for each open OP_IF, telling whether its
+	// current branch runs
+	conditions []bool
+	numOps     int
+}
+
+// execute runs the script on the engine's stack
+func (vm *scriptEngine) execute(script []byte) error {
+	if len(script) > maxScriptSize {
+		return fmt.Errorf("script is %d bytes, more than %d", len(script), maxScriptSize)
+	}
+
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	vm.conditions = nil
+	vm.numOps = 0
+
+	for _, op := range ops {
+		if len(op.data) > maxScriptElementSize {
+			return fmt.Errorf("script pushes %d bytes, more than %d", len(op.data), maxScriptElementSize)
+		}
+
+		if op.opcode > Op16 {
+			vm.numOps++
+			if vm.numOps > maxOpsPerScript {
+				return fmt.Errorf("script has more than %d operations", maxOpsPerScript)
+			}
+		}
+
+		isConditional := op.opcode >= OpIf && op.opcode <= OpEndIf
+		if !vm.executing() && !isConditional {
+			continue
+		}
+
+		err := vm.step(op)
+		if err != nil {
+			return fmt.Errorf("%s: %s", opcodeName(op.opcode), err)
+		}
+
+		if len(vm.stack) > maxStackSize {
+			return fmt.Errorf("stack has more than %d elements", maxStackSize)
+		}
+	}
+
+	if len(vm.conditions) != 0 {
+		return fmt.Errorf("OP_IF is not closed by OP_ENDIF")
+	}
+
+	return nil
+}
+
+// succeeded reports whether the top of the stack is true
+func (vm *scriptEngine) succeeded() bool {
+	return len(vm.stack) > 0 && asBool(vm.stack[len(vm.stack)-1])
+}
+
+// executing reports whether all open branches run
+func (vm *scriptEngine) executing() bool {
+	for _, condition := range vm.conditions {
+		if !condition {
+			return false
+		}
+	}
+
+	return true
+}
+
+// step runs a single opcode
+func (vm *scriptEngine) step(op parsedOpcode) error {
+	switch {
+	case op.opcode == Op0:
+		vm.push([]byte{})
+		return nil
+	case op.opcode <= OpPushData2:
+		vm.push(op.data)
+		return nil
+	case op.opcode == Op1Negate:
+		vm.push(scriptNumBytes(-1))
+		return nil
+	case op.opcode >= Op1 && op.opcode <= Op16:
+		vm.push(scriptNumBytes(int64(op.opcode - Op1 + 1)))
+		return nil
+	}
+
+	switch op.opcode {
+	case OpNop:
+
+	case OpIf, OpNotIf:
+		condition := false
+		if vm.executing() {
+			data, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			condition = asBool(data) == (op.opcode == OpIf)
+		}
+		vm.conditions = append(vm.conditions, condition)
+
+	case OpElse:
+		if len(vm.conditions) == 0 {
+			return fmt.Errorf("no OP_IF to continue")
+		}
+		vm.conditions[len(vm.conditions)-1] = !vm.conditions[len(vm.conditions)-1]
+
+	case OpEndIf:
+		if len(vm.conditions) == 0 {
+			return fmt.Errorf("no OP_IF to close")
+		}
+		vm.conditions = vm.conditions[:len(vm.conditions)-1]
+
+	case OpVerify:
+		return vm.verify()
+
+	case OpReturn:
+		return fmt.Errorf("output is unspendable")
+
+	case OpDrop:
+		_, err := vm.pop()
+		return err
+
+	case OpDup:
+		data, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.push(data)
+
+	case OpSwap:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(a)
+		vm.push(b)
+
+	case OpSize:
+		data, err := vm.peek(0)
+		if err != nil {
+			return err
+		}
+		vm.push(scriptNumBytes(int64(len(data))))
+
+	case OpEqual, OpEqualVerify:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(bytes.Equal(a, b))
+
+		if op.opcode == OpEqualVerify {
+			return vm.verify()
+		}
+
+	case OpSHA256, OpHash160, OpHash256:
+		data, err := vm.pop()
+		if err != nil {
+			return err
+		}
+
+		switch op.opcode {
+		case OpSHA256:
+			hash := sha256.Sum256(data)
+			vm.push(hash[:])
+		case OpHash160:
+			vm.push(HashPubKey(data))
+		case OpHash256:
+			first := sha256.Sum256(data)
+			second := sha256.Sum256(first[:])
+			vm.push(second[:])
+		}
+
+	case OpCheckSig, OpCheckSigVerify:
+		pubKey, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(vm.checkSignature(signature, pubKey))
+
+		if op.opcode == OpCheckSigVerify {
+			return vm.verify()
+		}
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		err := vm.checkMultiSig()
+		if err != nil {
+			return err
+		}
+
+		if op.opcode == OpCheckMultiSigVerify {
+			return vm.verify()
+		}
+
+	case OpCheckLockTimeVerify:
+		return vm.checkLockTime()
+
+	case OpCheckSequenceVerify:
+		return vm.checkSequence()
+
+	default:
+		return fmt.Errorf("unknown opcode")
+	}
+
+	return nil
+}
+
+func (vm *scriptEngine) push(data []byte) {
+	vm.stack = append(vm.stack, data)
+}
+
+func (vm *scriptEngine) pushBool(value bool) {
+	if value {
+		vm.push([]byte{1})
+	} else {
+		vm.push([]byte{})
+	}
+}
+
+func (vm *scriptEngine) pop() ([]byte, error) {
+	data, err := vm.peek(0)
+	if err != nil {
+		return nil, err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+
+	return data, nil
+}
+
+// peek returns the element depth places below the top of the stack
+func (vm *scriptEngine) peek(depth int) ([]byte, error) {
+	if depth >= len(vm.stack) {
+		return nil, fmt.Errorf("stack has %d elements, %d are needed", len(vm.stack), depth+1)
+	}
+
+	return vm.stack[len(vm.stack)-1-depth], nil
+}
+
+func (vm *scriptEngine) popInt() (int64, error) {
+	data, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return scriptNum(data, maxScriptNumLen)
+}
+
+// verify pops the top of the stack and fails unless it is true
+func (vm *scriptEngine) verify() error {
+	data, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !asBool(data) {
+		return fmt.Errorf("verification failed")
+	}
+
+	return nil
+}
+
+// checkSignature reports whether the signature, ending with its hash type,
+// was made by the public key over the signature hash of the input
+func (vm *scriptEngine) checkSignature(signature, pubKey []byte) bool {
+	if len(signature) != signatureLen {
+		return false
+	}
+
+	hashType := SigHashType(signature[signatureLen-1])
+	sighash, err := vm.tx.SignatureHash(vm.inIndex, vm.spent, hashType)
+	if err != nil {
+		return false
+	}
+
+	return verifySignature(pubKey, sighash, signature[:signatureLen-1])
+}
+
+// checkMultiSig pops n public keys and m signatures, with their counts, and
+// pushes whether every signature belongs to a different one of the keys.
+// Signatures must be in the same order as their keys. Unlike Bitcoin, no
+// extra element is popped.
+func (vm *scriptEngine) checkMultiSig() error {
+	numKeys, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	if numKeys < 0 || numKeys > maxPubKeysPerMultiSig {
+		return fmt.Errorf("%d public keys, at most %d are allowed", numKeys, maxPubKeysPerMultiSig)
+	}
+
+	vm.numOps += int(numKeys)
+	if vm.numOps > maxOpsPerScript {
+		return fmt.Errorf("script has more than %d operations", maxOpsPerScript)
+	}
+
+	pubKeys := make([][]byte, numKeys)
+	for i := len(pubKeys) - 1; i >= 0; i-- {
+		pubKeys[i], err = vm.pop()
+		if err != nil {
+			return err
+		}
+	}
+
+	numSignatures, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	if numSignatures < 0 || numSignatures > numKeys {
+		return fmt.Errorf("%d signatures for %d public keys", numSignatures, numKeys)
+	}
+
+	signatures := make([][]byte, numSignatures)
+	for i := len(signatures) - 1; i >= 0; i-- {
+		signatures[i], err = vm.pop()
+		if err != nil {
+			return err
+		}
+	}
+
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !vm.checkSignature(signature, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			vm.pushBool(false)
+			return nil
+		}
+		key++
+	}
+
+	vm.pushBool(true)
+
+	return nil
+}
+
+// checkLockTime fails unless the lock time of the transaction is at least
+// the one on top of the stack, which stays there. Both must be heights or
+// both timestamps.
+func (vm *scriptEngine) checkLockTime() error {
+	data, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+
+	lockTime, err := scriptNum(data, maxScriptNumLen+1)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return fmt.Errorf("negative lock time %d", lockTime)
+	}
+
+	if (lockTime < lockTimeThreshold) != (vm.tx.LockTime < lockTimeThreshold) {
+		return fmt.Errorf("lock time %d and transaction lock time %d are of different kinds", lockTime, vm.tx.LockTime)
+	}
+	if lockTime > vm.tx.LockTime {
+		return fmt.Errorf("output is locked until %d, transaction lock time is %d", lockTime, vm.tx.LockTime)
+	}
+
+	return nil
+}
+
+// checkSequence fails unless the relative lock time of the input is at
+// least the one on top of the stack, which stays there. Both must count
+// blocks or both seconds. A relative lock time with the disable flag set
+// always passes.
+func (vm *scriptEngine) checkSequence() error {
+	data, err := vm.peek(0)
+	if err != nil {
+		return err
+	}
+
+	n, err := scriptNum(data, maxScriptNumLen+1)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("negative relative lock time %d", n)
+	}
+
+	sequence := uint32(n)
+	if sequence&SequenceLockTimeDisabled != 0 {
+		return nil
+	}
+
+	txSequence := vm.tx.Vin[vm.inIndex].Sequence
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return fmt.Errorf("input has no relative lock time")
+	}
+
+	if sequence&SequenceLockTimeIsSeconds != txSequence&SequenceLockTimeIsSeconds {
+		return fmt.Errorf("relative lock time %#x and input sequence %#x are of different kinds", sequence, txSequence)
+	}
+	if sequence&SequenceLockTimeMask > txSequence&SequenceLockTimeMask {
+		return fmt.Errorf("output is locked for %d, input sequence allows %d", sequence&SequenceLockTimeMask, txSequence&SequenceLockTimeMask)
+	}
+
+	return nil
+}
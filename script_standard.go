@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// maxDataCarrierSize is the most data an OP_RETURN output may carry
+const maxDataCarrierSize = 80
+
+// PayToPubKeyHashScript returns the script paying to a public key hash:
+//
+//	OP_DUP OP_HASH160 <pubkey hash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// It is unlocked by <signature> <public key>.
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	script := []byte{OpDup, OpHash160}
+	script = pushData(script, pubKeyHash)
+
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// MultiSigScript returns the script that needs signatures of required of the
+// public keys:
+//
+//	<required> <pubkey 1> ... <pubkey n> <n> OP_CHECKMULTISIG
+//
+// It is unlocked by the signatures in the order of their keys.
+func MultiSigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxPubKeysPerMultiSig {
+		return nil, fmt.Errorf("multisig needs 1 to %d public keys, got %d", maxPubKeysPerMultiSig, len(pubKeys))
+	}
+	if required < 1 || required > len(pubKeys) {
+		return nil, fmt.Errorf("multisig cannot require %d of %d signatures", required, len(pubKeys))
+	}
+
+	script := pushInt(nil, int64(required))
+	for _, pubKey := range pubKeys {
+		script = pushData(script, pubKey)
+	}
+	script = pushInt(script, int64(len(pubKeys)))
+
+	return append(script, OpCheckMultiSig), nil
+}
+
+// HashLockScript returns the script paying to a public key hash once the
+// preimage of a SHA-256 hash is revealed:
+//
+//	OP_SHA256 <hash> OP_EQUALVERIFY OP_DUP OP_HASH160 <pubkey hash> OP_EQUALVERIFY OP_CHECKSIG
+//
+// It is unlocked by <signature> <public key> <preimage>.
+func HashLockScript(hash, pubKeyHash []byte) []byte {
+	script := []byte{OpSHA256}
+	script = pushData(script, hash)
+	script = append(script, OpEqualVerify)
+
+	return append(script, PayToPubKeyHashScript(pubKeyHash)...)
+}
+
+// NullDataScript returns the script of an output that carries data and can
+// never be spent:
+//
+//	OP_RETURN <data>
+func NullDataScript(data []byte) ([]byte, error) {
+	if len(data) > maxDataCarrierSize {
+		return nil, fmt.Errorf("data carrier outputs hold at most %d bytes, got %d", maxDataCarrierSize, len(data))
+	}
+
+	return pushData([]byte{OpReturn}, data), nil
+}
+
+// PayToScriptHashScript returns the script paying to the hash of a redeem
+// script:
+//
+//	OP_HASH160 <script hash> OP_EQUAL
+//
+// It is unlocked by pushes satisfying the redeem script followed by the
+// redeem script itself, which then runs on the pushes.
+func PayToScriptHashScript(scriptHash []byte) []byte {
+	script := []byte{OpHash160}
+	script = pushData(script, scriptHash)
+
+	return append(script, OpEqual)
+}
+
+// ScriptHash returns the hash a pay-to-script-hash output commits to, the
+// same HASH160 public keys are hashed with
+func ScriptHash(redeemScript []byte) []byte {
+	return HashPubKey(redeemScript)
+}
+
+// SignatureScript returns the script unlocking a pay-to-pubkey-hash output
+func SignatureScript(signature, pubKey []byte) []byte {
+	return pushData(pushData(nil, signature), pubKey)
+}
+
+// extractPubKeyHash returns the public key hash a pay-to-pubkey-hash script
+// pays to, or nil for other scripts
+func extractPubKeyHash(script []byte) []byte {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil
+	}
+
+	if ops[0].opcode != OpDup || ops[1].opcode != OpHash160 || len(ops[2].data) == 0 ||
+		ops[3].opcode != OpEqualVerify || ops[4].opcode != OpCheckSig {
+		return nil
+	}
+
+	if !bytes.Equal(script, PayToPubKeyHashScript(ops[2].data)) {
+		return nil
+	}
+
+	return ops[2].data
+}
+
+// extractMultiSig returns the number of required signatures and the public
+// keys of a multisig script, ok is false for other scripts
+func extractMultiSig(script []byte) (required int, pubKeys [][]byte, ok bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].opcode != OpCheckMultiSig {
+		return 0, nil, false
+	}
+
+	first, last := ops[0].opcode, ops[len(ops)-2].opcode
+	if first < Op1 || first > Op16 || last < Op1 || last > Op16 || int(last-Op1+1) != len(ops)-3 {
+		return 0, nil, false
+	}
+
+	for _, op := range ops[1 : len(ops)-2] {
+		pubKeys = append(pubKeys, op.data)
+	}
+	required = int(first - Op1 + 1)
+
+	canonical, err := MultiSigScript(required, pubKeys)
+	if err != nil || !bytes.Equal(canonical, script) {
+		return 0, nil, false
+	}
+
+	return required, pubKeys, true
+}
+
+// extractScriptHash returns the redeem script hash a pay-to-script-hash
+// script pays to, or nil for other scripts
+func extractScriptHash(script []byte) []byte {
+	if len(script) != 23 || script[0] != OpHash160 || script[1] != 20 || script[22] != OpEqual {
+		return nil
+	}
+
+	return script[2:22]
+}
+
+// isUnspendable reports whether the script can never be unlocked
+func isUnspendable(script []byte) bool {
+	return len(script) > 0 && script[0] == OpReturn || len(script) > maxScriptSize
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptNum(t *testing.T) {
+	vectors := []struct {
+		n       int64
+		encoded string
+	}{
+		{0, ""},
+		{1, "01"},
+		{-1, "81"},
+		{127, "7f"},
+		{128, "8000"},
+		{-128, "8080"},
+		{255, "ff00"},
+		{256, "0001"},
+		{-256, "0081"},
+		{500000000, "0065cd1d"},
+		{0x80000000, "0000008000"},
+	}
+
+	for _, vector := range vectors {
+		assert.Equal(t, vector.encoded, hex.EncodeToString(scriptNumBytes(vector.n)), "Encoding of %d", vector.n)
+
+		n, err := scriptNum(scriptNumBytes(vector.n), 5)
+		assert.Nil(t, err)
+		assert.Equal(t, vector.n, n, "Decoding of %s", vector.encoded)
+	}
+
+	_, err := scriptNum([]byte{1, 2, 3, 4, 5}, maxScriptNumLen)
+	assert.NotNil(t, err, "Numbers longer than the limit are rejected")
+}
+
+func TestScriptVectors(t *testing.T) {
+	// Scripts in hex, evaluated as input 0 of a transaction with lock time
+	// 100 whose input has sequence 10
+	vectors := []struct {
+		scriptSig    string
+		scriptPubKey string
+		valid        bool
+		comment      string
+	}{
+		{"", "51", true, "OP_1"},
+		{"", "00", false, "OP_0"},
+		{"", "", false, "empty stack"},
+		{"0180", "", false, "negative zero is false"},
+		{"0102", "75", false, "OP_DROP leaves an empty stack"},
+		{"5151", "51", false, "elements left below the result"},
+		{"0102", "010287", true, "OP_EQUAL"},
+		{"0102", "010387", false, "OP_EQUAL on different data"},
+		{"5152", "7c51885287", true, "OP_SWAP"},
+		{"06736563726574", "825688", true, "OP_SIZE of secret is 6"},
+		{"06736563726574", "a8202bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b87", true, "OP_SHA256 hash lock"},
+		{"06736563726575", "a8202bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b87", false, "OP_SHA256 hash lock, wrong preimage"},
+		{"06736563726574", "aa203881219d087dd9c634373fd33dfa33a2cb6bfc6c520b64b8bb60ef2ceb534ae787", true, "OP_HASH256 hash lock"},
+		{"51", "635267536852 87", true, "OP_IF takes the first branch"},
+		{"00", "635267536852 87", false, "OP_IF takes the second branch"},
+		{"00", "645267536852 87", true, "OP_NOTIF"},
+		{"", "0063506851", true, "unknown opcodes in branches that do not run are skipped"},
+		{"51", "50", false, "unknown opcode"},
+		{"", "516351", false, "OP_IF without OP_ENDIF"},
+		{"", "6751", false, "OP_ELSE without OP_IF"},
+		{"51", "6a", false, "OP_RETURN"},
+		{"", "6a0568656c6c6f", false, "data carrier output"},
+		{"5176", "87", false, "signature script that does not only push"},
+		{"", "0201", false, "push past the end of the script"},
+		{"", "76", false, "stack underflow"},
+		{"", "0164b17551", true, "OP_CHECKLOCKTIMEVERIFY below the lock time"},
+		{"", "0165b17551", false, "OP_CHECKLOCKTIMEVERIFY above the lock time"},
+		{"", "040065cd1db17551", false, "OP_CHECKLOCKTIMEVERIFY with a timestamp"},
+		{"", "4fb17551", false, "OP_CHECKLOCKTIMEVERIFY with a negative lock time"},
+		{"", "5ab27551", true, "OP_CHECKSEQUENCEVERIFY below the sequence"},
+		{"", "5bb27551", false, "OP_CHECKSEQUENCEVERIFY above the sequence"},
+		{"", "050000008000b27551", true, "OP_CHECKSEQUENCEVERIFY with the disable flag"},
+		{"", "03010040b27551", false, "OP_CHECKSEQUENCEVERIFY counting seconds"},
+		{"", "51ac", false, "OP_CHECKSIG with too few elements"},
+	}
+
+	tx := &Transaction{nil, []TXInput{{Txid: make([]byte, 32), Sequence: 10}}, []TXOutput{{Value: 1}}, 100}
+
+	for _, vector := range vectors {
+		scriptSig, err := hex.DecodeString(vector.scriptSig)
+		assert.Nil(t, err)
+		scriptPubKey, err := hex.DecodeString(string(bytes.Replace([]byte(vector.scriptPubKey), []byte(" "), nil, -1)))
+		assert.Nil(t, err)
+
+		err = VerifyScript(scriptSig, scriptPubKey, tx, 0, TXOutput{Value: 1, ScriptPubKey: scriptPubKey})
+		assert.Equal(t, vector.valid, err == nil, "%s: %v", vector.comment, err)
+	}
+}
+
+func TestStandardScripts(t *testing.T) {
+	pubKeyHash := bytes.Repeat([]byte{0x0c}, 20)
+
+	script := PayToPubKeyHashScript(pubKeyHash)
+	assert.Equal(t, "76a9140c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c88ac", hex.EncodeToString(script))
+	assert.Equal(t, "OP_DUP OP_HASH160 0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c OP_EQUALVERIFY OP_CHECKSIG", DisasmScript(script))
+	assert.Equal(t, pubKeyHash, extractPubKeyHash(script))
+	assert.Nil(t, extractPubKeyHash(append(script, OpNop)))
+
+	legacy := TXOutput{Value: 1, PubKeyHash: pubKeyHash}
+	scripted := NewScriptOutput(1, script)
+	assert.Equal(t, script, legacy.LockingScript(), "Outputs without a script pay to their pubkey hash")
+	assert.True(t, legacy.IsLockedWithKey(pubKeyHash))
+	assert.True(t, scripted.IsLockedWithKey(pubKeyHash))
+
+	data, err := NullDataScript([]byte("hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, "OP_RETURN 68656c6c6f", DisasmScript(data))
+	assert.True(t, isUnspendable(data))
+	assert.False(t, isUnspendable(script))
+	_, err = NullDataScript(make([]byte, maxDataCarrierSize+1))
+	assert.NotNil(t, err)
+
+	_, err = MultiSigScript(3, [][]byte{{1}, {2}})
+	assert.NotNil(t, err, "More signatures than keys are rejected")
+	_, err = MultiSigScript(0, [][]byte{{1}, {2}})
+	assert.NotNil(t, err, "Zero signatures are rejected")
+}
+
+// testScriptSpend builds a transaction spending an output locked by the
+// script
+func testScriptSpend(script []byte) (*Transaction, map[string]Transaction) {
+	prevTx := Transaction{nil, []TXInput{{Txid: []byte{}, Vout: -1}}, []TXOutput{*NewScriptOutput(10, script)}, 0}
+	prevTx.ID = prevTx.Hash()
+
+	tx := &Transaction{nil, []TXInput{{Txid: prevTx.ID, Vout: 0}}, []TXOutput{{Value: 9, PubKeyHash: make([]byte, 20)}}, 0}
+	tx.ID = tx.Hash()
+
+	return tx, map[string]Transaction{hex.EncodeToString(prevTx.ID): prevTx}
+}
+
+// signScript returns the signatures of the wallets for input 0 of tx
+func signScript(t *testing.T, tx *Transaction, prevTXs map[string]Transaction, wallets ...*Wallet) [][]byte {
+	spent := prevTXs[hex.EncodeToString(tx.Vin[0].Txid)].Vout[0]
+
+	var signatures [][]byte
+	for _, wallet := range wallets {
+		signature, err := tx.InputSignature(wallet.PrivateKey, 0, spent, SigHashAll)
+		assert.Nil(t, err)
+		signatures = append(signatures, signature)
+	}
+
+	return signatures
+}
+
+func TestScriptSignatures(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+
+	// Pay-to-pubkey-hash in a script
+	tx, prevTXs := testScriptSpend(PayToPubKeyHashScript(HashPubKey(alice.PublicKey)))
+	signature := signScript(t, tx, prevTXs, alice)[0]
+	tx.Vin[0].ScriptSig = SignatureScript(signature, alice.PublicKey)
+	assert.True(t, tx.Verify(prevTXs))
+	assert.Equal(t, tx.Hash(), tx.ID, "Signature scripts are not part of the ID")
+	tx.Vin[0].ScriptSig = SignatureScript(signScript(t, tx, prevTXs, bob)[0], bob.PublicKey)
+	assert.False(t, tx.Verify(prevTXs), "Another key does not unlock the output")
+
+	// 2-of-3 multisig
+	script, err := MultiSigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	assert.Nil(t, err)
+	tx, prevTXs = testScriptSpend(script)
+	signatures := signScript(t, tx, prevTXs, alice, bob, carol)
+
+	cases := []struct {
+		signatures [][]byte
+		valid      bool
+		comment    string
+	}{
+		{[][]byte{signatures[0], signatures[2]}, true, "Alice and Carol sign"},
+		{[][]byte{signatures[1], signatures[2]}, true, "Bob and Carol sign"},
+		{[][]byte{signatures[2], signatures[0]}, false, "Signatures out of the order of their keys"},
+		{[][]byte{signatures[0], signatures[0]}, false, "Alice signs twice"},
+		{[][]byte{signatures[0]}, false, "Alice signs alone"},
+		{[][]byte{signatures[0], signatures[1], signatures[2]}, false, "An extra signature is left on the stack"},
+	}
+	for _, c := range cases {
+		tx.Vin[0].ScriptSig = nil
+		for _, signature := range c.signatures {
+			tx.Vin[0].ScriptSig = pushData(tx.Vin[0].ScriptSig, signature)
+		}
+		assert.Equal(t, c.valid, tx.Verify(prevTXs), c.comment)
+	}
+
+	// Hash lock
+	secret := []byte("secret")
+	hash := sha256.Sum256(secret)
+	tx, prevTXs = testScriptSpend(HashLockScript(hash[:], HashPubKey(alice.PublicKey)))
+	signature = signScript(t, tx, prevTXs, alice)[0]
+	tx.Vin[0].ScriptSig = pushData(SignatureScript(signature, alice.PublicKey), secret)
+	assert.True(t, tx.Verify(prevTXs))
+	tx.Vin[0].ScriptSig = pushData(SignatureScript(signature, alice.PublicKey), []byte("guess"))
+	assert.False(t, tx.Verify(prevTXs), "The hash lock needs the preimage")
+
+	// Lock time, the signature commits to the lock time of the transaction
+	script = pushInt(nil, 1000)
+	script = append(script, OpCheckLockTimeVerify, OpDrop)
+	script = append(script, PayToPubKeyHashScript(HashPubKey(alice.PublicKey))...)
+	tx, prevTXs = testScriptSpend(script)
+	tx.LockTime = 1000
+	tx.ID = tx.Hash()
+	tx.Vin[0].ScriptSig = SignatureScript(signScript(t, tx, prevTXs, alice)[0], alice.PublicKey)
+	assert.True(t, tx.Verify(prevTXs))
+	tx.LockTime = 999
+	tx.Vin[0].ScriptSig = SignatureScript(signScript(t, tx, prevTXs, alice)[0], alice.PublicKey)
+	assert.False(t, tx.Verify(prevTXs), "The output is locked until height 1000")
+
+	// Relative lock time
+	script = pushInt(nil, 6)
+	script = append(script, OpCheckSequenceVerify, OpDrop)
+	script = append(script, PayToPubKeyHashScript(HashPubKey(alice.PublicKey))...)
+	tx, prevTXs = testScriptSpend(script)
+	tx.Vin[0].Sequence = 6
+	tx.Vin[0].ScriptSig = SignatureScript(signScript(t, tx, prevTXs, alice)[0], alice.PublicKey)
+	assert.True(t, tx.Verify(prevTXs))
+	tx.Vin[0].Sequence = 7
+	assert.False(t, tx.Verify(prevTXs), "The sequence is signed")
+
+	// Data carrier outputs cannot be spent
+	data, _ := NullDataScript([]byte("hello"))
+	tx, prevTXs = testScriptSpend(data)
+	tx.Vin[0].ScriptSig = SignatureScript(signScript(t, tx, prevTXs, alice)[0], alice.PublicKey)
+	assert.False(t, tx.Verify(prevTXs))
+}
+
+func TestLockTimes(t *testing.T) {
+	tx := Transaction{}
+	assert.True(t, tx.IsFinal(1, 0), "A zero lock time never locks")
+
+	tx.LockTime = 10
+	assert.False(t, tx.IsFinal(10, 0))
+	assert.True(t, tx.IsFinal(11, 0))
+
+	tx.LockTime = lockTimeThreshold + 100
+	assert.False(t, tx.IsFinal(1000000, lockTimeThreshold+100))
+	assert.True(t, tx.IsFinal(1, lockTimeThreshold+101))
+
+	assert.True(t, sequenceLockSatisfied(0, 5, 0, 5, 0), "A zero sequence never locks")
+	assert.False(t, sequenceLockSatisfied(3, 5, 0, 7, 0))
+	assert.True(t, sequenceLockSatisfied(3, 5, 0, 8, 0))
+	assert.True(t, sequenceLockSatisfied(SequenceLockTimeDisabled|3, 5, 0, 5, 0))
+	assert.False(t, sequenceLockSatisfied(SequenceLockTimeIsSeconds|2, 5, 1000, 100, 1000+1023))
+	assert.True(t, sequenceLockSatisfied(SequenceLockTimeIsSeconds|2, 5, 1000, 100, 1000+1024))
+}
+
+func TestPayToScriptHash(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+	redeemScript, err := MultiSigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+	assert.Nil(t, err)
+
+	address := string(ScriptAddress(redeemScript))
+	assert.True(t, ValidateAddress(address))
+	assert.True(t, IsScriptAddress(address))
+	assert.False(t, IsScriptAddress(string(alice.GetAddress())))
+	assert.False(t, ValidateAddress(""))
+	assert.False(t, ValidateAddress("0OIl"), "Characters outside the alphabet are rejected")
+
+	out := NewTXOutput(10, address)
+	assert.Nil(t, out.PubKeyHash)
+	assert.Equal(t, PayToScriptHashScript(ScriptHash(redeemScript)), out.ScriptPubKey)
+	assert.Equal(t, "OP_HASH160 "+hex.EncodeToString(ScriptHash(redeemScript))+" OP_EQUAL", DisasmScript(out.ScriptPubKey))
+
+	tx, prevTXs := testScriptSpend(out.ScriptPubKey)
+	signatures := signScript(t, tx, prevTXs, alice, bob, carol)
+	otherScript, _ := MultiSigScript(1, [][]byte{alice.PublicKey})
+
+	cases := []struct {
+		pushes  [][]byte
+		valid   bool
+		comment string
+	}{
+		{[][]byte{signatures[0], signatures[2], redeemScript}, true, "Alice and Carol reveal the redeem script"},
+		{[][]byte{signatures[0], redeemScript}, false, "A single signature does not satisfy the redeem script"},
+		{[][]byte{redeemScript}, false, "The redeem script alone"},
+		{[][]byte{signatures[0], signatures[2]}, false, "No redeem script"},
+		{[][]byte{signatures[0], otherScript}, false, "A redeem script with another hash"},
+	}
+	for _, c := range cases {
+		tx.Vin[0].ScriptSig = nil
+		for _, push := range c.pushes {
+			tx.Vin[0].ScriptSig = pushData(tx.Vin[0].ScriptSig, push)
+		}
+		assert.Equal(t, c.valid, tx.Verify(prevTXs), c.comment)
+	}
+
+	// A timelocked redeem script
+	redeemScript = pushInt(nil, 1000)
+	redeemScript = append(redeemScript, OpCheckLockTimeVerify, OpDrop)
+	redeemScript = append(redeemScript, PayToPubKeyHashScript(HashPubKey(alice.PublicKey))...)
+	tx, prevTXs = testScriptSpend(NewTXOutput(10, string(ScriptAddress(redeemScript))).ScriptPubKey)
+	for _, lockTime := range []int64{999, 1000} {
+		tx.LockTime = lockTime
+		signature := signScript(t, tx, prevTXs, alice)[0]
+		tx.Vin[0].ScriptSig = pushData(SignatureScript(signature, alice.PublicKey), redeemScript)
+		assert.Equal(t, lockTime >= 1000, tx.Verify(prevTXs), "Lock time %d", lockTime)
+	}
+}
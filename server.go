@@ -17,6 +17,7 @@ import (
 	"runtime"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/libp2p/go-libp2p"
@@ -33,7 +34,6 @@ import (
 )
 
 const protocol = "tcp"
-const nodeVersion = 1
 const commandLength = 12
 
 var (
@@ -48,7 +48,15 @@ var nodeAddress string
 var miningAddress string
 var knownNodes = []string{"localhost:3000"}
 var blocksInTransit = [][]byte{}
-var mempool = make(map[string]Transaction)
+var mempool = NewMempool()
+
+// refusedPeers holds the peers whose last version message named another
+// genesis block, keyed by the remote host of their connections or by their
+// libp2p peer ID. Their other messages are ignored.
+var refusedPeers = struct {
+	sync.Mutex
+	peers map[string]bool
+}{peers: make(map[string]bool)}
 
 type addr struct {
 	AddrList []string
@@ -81,9 +89,11 @@ type tx struct {
 }
 
 type version struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
+	Version     int
+	BestHeight  int
+	AddrFrom    string
+	Timestamp   int64
+	GenesisHash []byte
 }
 
 func commandToBytes(command string) []byte {
@@ -200,11 +210,11 @@ func sendTxOnce(addr string, tnx *Transaction) {
 
 func sendVersion(addr string, bc *BlockChain) {
 	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(version{nodeVersion, bestHeight, nodeAddress})
+	payload := gobEncode(version{activeNetParams.NodeVersion, bestHeight, nodeAddress, time.Now().Unix(), bc.GenesisHash()})
 
 	request := append(commandToBytes("version"), payload...)
 
-	log.Printf("Send Version {version: %d, height: %d} to %s\n", ver, bestHeight, addr)
+	log.Printf("Send Version {version: %d, height: %d} to %s\n", activeNetParams.NodeVersion, bestHeight, addr)
 
 	sendData(addr, request)
 }
@@ -237,21 +247,56 @@ func handleBlock(request []byte, bc *BlockChain) {
 	}
 
 	blockData := payload.Block
-	block := DeserializeBlock(blockData)
+	block, err := decodeBlock(blockData)
+	if err != nil {
+		fmt.Printf("Rejected block from %s: %s\n", payload.AddrFrom, err)
+		return
+	}
 
 	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+	err = bc.AddBlock(block)
+	if ruleErr, ok := err.(RuleError); ok && ruleErr.Code == RejectOrphan {
+		orphans.Add(block)
+		missing := orphans.MissingAncestor(block.Hash)
+		fmt.Printf("Block %x is an orphan, requesting %x\n", block.Hash, missing)
+		sendGetData(payload.AddrFrom, "block", missing)
+		return
+	}
 
-	fmt.Printf("Added block %x\n", block.Hash)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+	} else {
+		fmt.Printf("Added block %x\n", block.Hash)
+		processOrphans(bc, block.Hash)
+	}
 
 	if len(blocksInTransit) > 0 {
 		blockHash := blocksInTransit[0]
 		sendGetData(payload.AddrFrom, "block", blockHash)
 
 		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
+	}
+}
+
+// processOrphans connects the orphans that were waiting for the given
+// block, and then the orphans waiting for those
+func processOrphans(bc *BlockChain, hash []byte) {
+	parents := [][]byte{hash}
+
+	for len(parents) > 0 {
+		children := orphans.TakeChildren(parents[0])
+		parents = parents[1:]
+
+		for _, child := range children {
+			err := bc.AddBlock(child)
+			if err != nil {
+				fmt.Printf("Rejected orphan block %x: %s\n", child.Hash, err)
+				continue
+			}
+
+			fmt.Printf("Added orphan block %x\n", child.Hash)
+			parents = append(parents, child.Hash)
+		}
 	}
 }
 
@@ -269,24 +314,27 @@ func handleInv(request []byte, bc *BlockChain) {
 	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
+		// Blocks are requested oldest first, so that every block arrives
+		// after its parent and can be attached to the chain
+		blocksInTransit = [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if _, err := bc.GetBlock(payload.Items[i]); err != nil && !orphans.Has(payload.Items[i]) {
+				blocksInTransit = append(blocksInTransit, payload.Items[i])
+			}
+		}
 
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+		if len(blocksInTransit) > 0 {
+			blockHash := blocksInTransit[0]
+			sendGetData(payload.AddrFrom, "block", blockHash)
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
+			blocksInTransit = blocksInTransit[1:]
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
+		if _, ok := mempool.Get(hex.EncodeToString(txID)); !ok {
 			sendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
@@ -329,14 +377,17 @@ func handleGetData(request []byte, bc *BlockChain) {
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := mempool.Get(txID)
+		if !ok {
+			return
+		}
 
 		sendTx(payload.AddrFrom, &tx)
 		// delete(mempool, txID)
 	}
 }
 
-func handleTxNet(request []byte, bc *BlockChain) {
+func handleTx(request []byte, bc *BlockChain) {
 	var buff bytes.Buffer
 	var payload tx
 
@@ -348,76 +399,21 @@ func handleTxNet(request []byte, bc *BlockChain) {
 	}
 
 	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
-
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddFrom {
-				sendInv(node, "tx", [][]byte{tx.ID})
-			}
-		}
-	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
-		MineTransactions:
-			var txs []*Transaction
-
-			for id := range mempool {
-				fmt.Printf("tx: ^s\n", mempool[id].ID)
-				tx := mempool[id]
-				if bc.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
-
-			if len(txs) == 0 {
-				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
-			}
-
-			cbTx := NewCoinbaseTX(miningAddress, "")
-			txs = append(txs, cbTx)
-
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Reindex()
-
-			fmt.Println("New block is mined!")
-
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
-			}
-
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
-
-			if len(mempool) > 0 {
-				goto MineTransactions
-			}
-		}
+	tx, err := decodeTransaction(txData)
+	if err != nil {
+		log.Printf("Rejected transaction from %s: %s\n", payload.AddFrom, err)
+		return
 	}
-}
-
-func handleTx(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
-	var payload tx
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	fee, err := checkMempoolTransaction(bc, &tx)
 	if err != nil {
-		log.Panic(err)
+		log.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return
 	}
 
-	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	mempool.Add(tx)
 
-	log.Printf("%s received Tx, now %d txs in memoryPool\n", nodeAddress, len(mempool))
+	log.Printf("%s received Tx paying fee %d, now %d txs in memoryPool\n", nodeAddress, fee, mempool.Count())
 
 	for _, node := range knownNodes {
 		if node != nodeAddress && node != payload.AddFrom {
@@ -425,50 +421,12 @@ func handleTx(request []byte, bc *BlockChain) {
 		}
 	}
 
-	if len(mempool) >= 2 && len(miningAddress) > 0 {
-	MineTransactions:
-		var txs []*Transaction
-
-		for id := range mempool {
-			fmt.Printf("tx: ^s\n", mempool[id].ID)
-			tx := mempool[id]
-			if bc.VerifyTransaction(&tx) {
-				txs = append(txs, &tx)
-			}
-		}
-
-		if len(txs) == 0 {
-			fmt.Println("All transactions are invalid! Waiting for new ones...")
-			return
-		}
-
-		cbTx := NewCoinbaseTX(miningAddress, "")
-		txs = append(txs, cbTx)
-
-		newBlock := bc.MineBlock(txs)
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
-
-		fmt.Println("New block is mined!")
-
-		for _, tx := range txs {
-			txID := hex.EncodeToString(tx.ID)
-			delete(mempool, txID)
-		}
-
-		for _, node := range knownNodes {
-			if node != nodeAddress {
-				sendInv(node, "block", [][]byte{newBlock.Hash})
-			}
-		}
-
-		if len(mempool) > 0 {
-			goto MineTransactions
-		}
+	if mempool.Count() >= 2 && len(miningAddress) > 0 {
+		mineMempool(bc)
 	}
 }
 
-func handleVersion(request []byte, bc *BlockChain) {
+func handleVersion(request []byte, bc *BlockChain, peer string) {
 	var buff bytes.Buffer
 	var payload version
 
@@ -479,6 +437,23 @@ func handleVersion(request []byte, bc *BlockChain) {
 		log.Panic(err)
 	}
 
+	if !bytes.Equal(payload.GenesisHash, bc.GenesisHash()) {
+		refusedPeers.Lock()
+		refusedPeers.peers[peer] = true
+		refusedPeers.Unlock()
+
+		log.Printf("Refusing peer %s with genesis block %x\n", peer, payload.GenesisHash)
+		return
+	}
+
+	refusedPeers.Lock()
+	delete(refusedPeers.peers, peer)
+	refusedPeers.Unlock()
+
+	if payload.Timestamp != 0 {
+		timeSource.AddTimeSample(payload.AddrFrom, payload.Timestamp)
+	}
+
 	myBestHeight := bc.GetBestHeight()
 	foreignerBestHeight := payload.BestHeight
 
@@ -494,6 +469,26 @@ func handleVersion(request []byte, bc *BlockChain) {
 	}
 }
 
+// isRefusedPeer reports whether the peer's last version message named
+// another genesis block
+func isRefusedPeer(peer string) bool {
+	refusedPeers.Lock()
+	defer refusedPeers.Unlock()
+
+	return refusedPeers.peers[peer]
+}
+
+// remoteHost returns the host a connection comes from. Every message arrives
+// on a new connection, so the port says nothing about the peer.
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return host
+}
+
 func handleConnection(conn net.Conn, bc *BlockChain) {
 	request, err := ioutil.ReadAll(conn)
 	if err != nil {
@@ -501,6 +496,13 @@ func handleConnection(conn net.Conn, bc *BlockChain) {
 	}
 	command := bytesToCommand(request[:commandLength])
 	fmt.Printf("Received %s command\n", command)
+	peer := remoteHost(conn)
+
+	if command != "version" && isRefusedPeer(peer) {
+		fmt.Printf("Ignoring %s command of a refused peer\n", command)
+		conn.Close()
+		return
+	}
 
 	switch command {
 	case "addr":
@@ -516,7 +518,7 @@ func handleConnection(conn net.Conn, bc *BlockChain) {
 	case "tx":
 		handleTx(request, bc)
 	case "version":
-		handleVersion(request, bc)
+		handleVersion(request, bc, peer)
 	default:
 		fmt.Println("Unknown command!")
 	}
@@ -538,6 +540,16 @@ func StartServer(nodeID, minerAddress string) {
 	defer bc.db.Close()
 	go CloseDB(bc)
 
+	if rpcAddress != "" {
+		go StartRPCServer(rpcAddress, bc)
+	}
+	if poolAddress != "" {
+		if miningAddress == "" {
+			log.Panic("ERROR: A pool needs a miner address")
+		}
+		go StartPool(poolAddress, bc, miningAddress)
+	}
+
 	if nodeAddress != knownNodes[0] {
 		sendVersion(knownNodes[0], bc)
 	}
@@ -636,7 +648,7 @@ func sendData(destPeerID string, data []byte) {
 	// 创建{ha}=>{peerID}的Stream
 	// 此Stream将由{peerID}主机的steamHandler处理
 
-	s, err := ha.NewStream(context.Background(), peerID, "/p2p/1.0.0")
+	s, err := ha.NewStream(context.Background(), peerID, activeNetParams.ProtocolID)
 
 	if err != nil {
 
@@ -695,10 +707,10 @@ func handleStream(s network.Stream) {
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
 	// connection处理异步处理为go例程
-	go HandleP2PConnection(rw, chain)
+	go HandleP2PConnection(rw, chain, s.Conn().RemotePeer().String())
 }
 
-func HandleP2PConnection(rw *bufio.ReadWriter, bc *BlockChain) {
+func HandleP2PConnection(rw *bufio.ReadWriter, bc *BlockChain, peer string) {
 	request, err := ioutil.ReadAll(rw)
 	if err != nil {
 		log.Panic(err)
@@ -708,6 +720,11 @@ func HandleP2PConnection(rw *bufio.ReadWriter, bc *BlockChain) {
 
 	fmt.Printf("Received %s command\n", command)
 
+	if command != "version" && isRefusedPeer(peer) {
+		fmt.Printf("Ignoring %s command of a refused peer\n", command)
+		return
+	}
+
 	switch command {
 	case "addr":
 		handleAddr(request)
@@ -722,7 +739,7 @@ func HandleP2PConnection(rw *bufio.ReadWriter, bc *BlockChain) {
 	case "tx":
 		handleTx(request, bc)
 	case "version":
-		handleVersion(request, bc)
+		handleVersion(request, bc, peer)
 	default:
 		log.Println("\033[1;31mUnknown command!\033[0m", string(request))
 	}
@@ -744,6 +761,16 @@ func startHost(listenPort int, minter string, secio bool, randseed int64, rendez
 	go CloseDB(chain) // 等待硬件中断并安全关闭DB的函数
 	defer chain.db.Close()
 
+	if rpcAddress != "" {
+		go StartRPCServer(rpcAddress, chain)
+	}
+	if poolAddress != "" {
+		if miningAddress == "" {
+			log.Panic("ERROR: A pool needs a minter address")
+		}
+		go StartPool(poolAddress, chain, miningAddress)
+	}
+
 	// 创建p2p host
 	host, err := makeBasicHost(listenPort, secio, randseed)
 
@@ -766,7 +793,7 @@ func startHost(listenPort int, minter string, secio bool, randseed int64, rendez
 	fullAddr := getHostAddress(ha)
 	log.Printf("I am %s\n", fullAddr)
 
-	ha.SetStreamHandler("/p2p/1.0.0", handleStream)
+	ha.SetStreamHandler(activeNetParams.ProtocolID, handleStream)
 
 	// 加载保存的对等方
 	peers, err = getPeerDB(nodeId)
@@ -800,7 +827,7 @@ func connectToKnownPeer(host host.Host, peers *Peers) bool {
 	for _, peerinfo := range peerAddrInfos {
 		// 创建{host}=>{peer}的Stream。
 		// 此Stream将由{peer}主机的steamHandler处理。
-		s, err := host.NewStream(context.Background(), peerinfo.ID, "/p2p/1.0.0")
+		s, err := host.NewStream(context.Background(), peerinfo.ID, activeNetParams.ProtocolID)
 		if err != nil {
 			log.Printf("%s is \033[1;33mnot reachable\033[0m\n", peerinfo.ID)
 			// 如果无法连接，请从peer DB中删除它。
@@ -873,7 +900,7 @@ func peerDiscovery(ctx context.Context, host host.Host, peers *Peers, rendezvous
 			// 将此信息存储在Peer DB中
 			peers.addPeer(p)
 			// 打开Stream
-			s, err := ha.NewStream(context.Background(), p.ID, "/p2p/1.0.0")
+			s, err := ha.NewStream(context.Background(), p.ID, activeNetParams.ProtocolID)
 			if err != nil {
 				log.Printf("%s is \033[1;33mnot reachable\033[0m\n", p.ID)
 				// 如果Stream创建出现错误，请从PeerDB中删除Peer。
@@ -902,7 +929,7 @@ func runListener(ctx context.Context, ha host.Host, listenPort int, secio bool)
 	// {handleStream}是收到stream时调用的处理程序函数
 	// p2p/1.0.0是user-defined protocal
 
-	ha.SetStreamHandler("/p2p/1.0.0", handleStream)
+	ha.SetStreamHandler(activeNetParams.ProtocolID, handleStream)
 
 	log.Printf("Now run \"go run main.go startp2p -dest %s\" on a different terminal\n", fullAddr)
 
@@ -914,7 +941,7 @@ func runSender(ctx context.Context, ha host.Host, targetPeer string) {
 	fullAddr := getHostAddress(ha)
 	log.Printf("I am %s\n", fullAddr)
 
-	ha.SetStreamHandler("/p2p/1.0.0", handleStream)
+	ha.SetStreamHandler(activeNetParams.ProtocolID, handleStream)
 
 	// 将targetPeer保存在ha的Peerstore中，并接收destination的peerId。
 
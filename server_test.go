@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// peerConn is a connection that reports the address of a peer
+type peerConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (conn peerConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+// deliver passes a message to handleConnection as the peer at the IP would
+func deliver(bc *BlockChain, ip string, command string, payload interface{}) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write(append(commandToBytes(command), gobEncode(payload)...))
+		client.Close()
+	}()
+
+	handleConnection(peerConn{server, &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}, bc)
+}
+
+func TestRefusePeerWithOtherGenesis(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc := newTestBlockChain(t, address)
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	defer func(nodes []string) {
+		knownNodes = nodes
+		refusedPeers.peers = make(map[string]bool)
+	}(knownNodes)
+
+	stranger, friend := "10.0.0.1", "10.0.0.2"
+	deliver(bc, stranger, "version", version{activeNetParams.NodeVersion, 0, "localhost:3901", 0, []byte("another genesis")})
+
+	newBlock := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 1, 0)}, genesis.Hash, 1, activeNetParams.GenesisBits, genesis.Timestamp+1)
+	deliver(bc, stranger, "block", block{"localhost:3902", newBlock.Serialize()})
+	assert.Equal(t, genesis.Hash, bc.tip, "The block of a refused peer is ignored, whatever address it claims")
+
+	deliver(bc, friend, "block", block{"localhost:3901", newBlock.Serialize()})
+	assert.Equal(t, newBlock.Hash, bc.tip, "Claiming a refused address does not get a peer refused")
+
+	// At our height, so that no reply is sent
+	deliver(bc, stranger, "version", version{activeNetParams.NodeVersion, 1, "localhost:3901", 0, genesis.Hash})
+	assert.False(t, isRefusedPeer(stranger), "A version with our genesis block lifts the refusal")
+}
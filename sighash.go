@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// signatureLen is the length of an input signature: r and s, each padded to
+// 32 bytes, followed by the hash type
+const signatureLen = 65
+
+// verifySignature checks a signature of r and s, each padded to 32 bytes,
+// by the public key over the hash
+func verifySignature(pubKey, hash, signature []byte) bool {
+	if len(pubKey) == 0 || len(signature) != 64 {
+		return false
+	}
+
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	return ecdsa.Verify(&rawPubKey, hash, r, s)
+}
+
+// SigHashType selects the parts of a transaction an input's signature
+// commits to
+type SigHashType byte
+
+const (
+	// SigHashAll signs all inputs and all outputs
+	SigHashAll SigHashType = 0x01
+	// SigHashNone signs all inputs and no outputs, anyone may choose where
+	// the coins go
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle signs all inputs and only the output with the same index
+	// as the signed input
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay is combined with one of the types above to sign
+	// only the signed input, others may add inputs of their own
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+// IsValid reports whether the hash type is one of the known combinations
+func (hashType SigHashType) IsValid() bool {
+	base := hashType &^ SigHashAnyoneCanPay
+
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+// SignatureHash returns the hash an input's signature commits to. It is the
+// double SHA-256 of the parts of the trimmed transaction the hash type
+// selects, the output the input spends and the hash type, serialized as
+// follows. Integers are big-endian and byte strings are prefixed with their
+// length as a uint32.
+//
+//	number of inputs (uint32)
+//	for each input: txid (bytes) | vout (int32) | sequence (uint32)
+//	number of outputs (uint32)
+//	for each output: value (int64) | pubkey hash (bytes) | script (bytes)
+//	input index (uint32), left out with SigHashAnyoneCanPay
+//	spent output: value (int64) | pubkey hash (bytes) | script (bytes)
+//	lock time (int64)
+//	hash type (uint32)
+//
+// With SigHashAnyoneCanPay only the signed input is listed. With SigHashNone
+// no outputs are listed, with SigHashSingle only the output with the index
+// of the signed input, which must exist. Signatures and public keys are not
+// part of the hash.
+func (tx *Transaction) SignatureHash(inIndex int, spent TXOutput, hashType SigHashType) ([]byte, error) {
+	if !hashType.IsValid() {
+		return nil, fmt.Errorf("unknown signature hash type %#x", byte(hashType))
+	}
+	if inIndex < 0 || inIndex >= len(tx.Vin) {
+		return nil, fmt.Errorf("transaction %x has no input %d", tx.ID, inIndex)
+	}
+
+	var buf bytes.Buffer
+	txCopy := tx.TrimmedCopy()
+
+	inputs := txCopy.Vin
+	if hashType&SigHashAnyoneCanPay != 0 {
+		inputs = inputs[inIndex : inIndex+1]
+	}
+
+	outputs := txCopy.Vout
+	switch hashType &^ SigHashAnyoneCanPay {
+	case SigHashNone:
+		outputs = nil
+	case SigHashSingle:
+		if inIndex >= len(outputs) {
+			return nil, fmt.Errorf("transaction %x has no output %d to sign with SigHashSingle", tx.ID, inIndex)
+		}
+		outputs = outputs[inIndex : inIndex+1]
+	}
+
+	writeUint32(&buf, uint32(len(inputs)))
+	for _, vin := range inputs {
+		writeBytes(&buf, vin.Txid)
+		writeUint32(&buf, uint32(int32(vin.Vout)))
+		writeUint32(&buf, vin.Sequence)
+	}
+
+	writeUint32(&buf, uint32(len(outputs)))
+	for _, vout := range outputs {
+		writeOutput(&buf, vout)
+	}
+
+	if hashType&SigHashAnyoneCanPay == 0 {
+		writeUint32(&buf, uint32(inIndex))
+	}
+	writeOutput(&buf, spent)
+	writeUint64(&buf, uint64(txCopy.LockTime))
+	writeUint32(&buf, uint32(hashType))
+
+	first := sha256.Sum256(buf.Bytes())
+	second := sha256.Sum256(first[:])
+
+	return second[:], nil
+}
+
+func writeOutput(buf *bytes.Buffer, out TXOutput) {
+	writeUint64(buf, uint64(int64(out.Value)))
+	writeBytes(buf, out.PubKeyHash)
+	writeBytes(buf, out.ScriptPubKey)
+}
+
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	writeUint32(buf, uint32(len(data)))
+	buf.Write(data)
+}
+
+func writeUint32(buf *bytes.Buffer, n uint32) {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], n)
+	buf.Write(data[:])
+}
+
+func writeUint64(buf *bytes.Buffer, n uint64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], n)
+	buf.Write(data[:])
+}
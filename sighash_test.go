@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureHash(t *testing.T) {
+	tx := Transaction{
+		Vin: []TXInput{
+			{Txid: bytes.Repeat([]byte{0x01}, 32), Vout: 0, Signature: []byte("sig"), PubKey: []byte("key")},
+			{Txid: bytes.Repeat([]byte{0x02}, 32), Vout: 1},
+		},
+		Vout: []TXOutput{
+			{Value: 7, PubKeyHash: bytes.Repeat([]byte{0x0a}, 20)},
+			{Value: 3, PubKeyHash: bytes.Repeat([]byte{0x0b}, 20)},
+		},
+	}
+	spent := TXOutput{Value: 12, PubKeyHash: bytes.Repeat([]byte{0x0c}, 20)}
+
+	vectors := []struct {
+		hashType SigHashType
+		hash     string
+	}{
+		{SigHashAll, "cd004ef4bdcdc885364ab8a7b232c9b8bce02f4407a72eaed3b8264a6d957d11"},
+		{SigHashSingle | SigHashAnyoneCanPay, "128ddc137e639a5d92a8a15d47f305b27f20306387b3c56fd4ff021f1515f356"},
+	}
+	for _, vector := range vectors {
+		sighash, err := tx.SignatureHash(1, spent, vector.hashType)
+		assert.Nil(t, err)
+		assert.Equal(t, vector.hash, hex.EncodeToString(sighash), "Signature hash %#x matches the documented serialization", byte(vector.hashType))
+	}
+
+	all0, _ := tx.SignatureHash(0, spent, SigHashAll)
+	all1, _ := tx.SignatureHash(1, spent, SigHashAll)
+	assert.NotEqual(t, all0, all1, "The input index is committed to")
+
+	_, err := tx.SignatureHash(1, spent, 0x04)
+	assert.NotNil(t, err, "Unknown hash types are rejected")
+	tx.Vout = tx.Vout[:1]
+	_, err = tx.SignatureHash(1, spent, SigHashSingle)
+	assert.NotNil(t, err, "SigHashSingle needs an output with the index of the input")
+}
+
+// testSpend builds a transaction spending a coinbase of each wallet
+func testSpend(wallets ...*Wallet) (*Transaction, map[string]Transaction) {
+	tx := &Transaction{}
+	prevTXs := make(map[string]Transaction)
+
+	for _, wallet := range wallets {
+		address := string(wallet.GetAddress())
+		prevTx := NewCoinbaseTX(address, "", 1, 0)
+		prevTXs[hex.EncodeToString(prevTx.ID)] = *prevTx
+
+		tx.Vin = append(tx.Vin, TXInput{Txid: prevTx.ID, Vout: 0, PubKey: wallet.PublicKey})
+		tx.Vout = append(tx.Vout, *NewTXOutput(4, address))
+	}
+	tx.ID = tx.Hash()
+
+	return tx, prevTXs
+}
+
+func TestSignAndVerify(t *testing.T) {
+	wallet := NewWallet()
+	tx, prevTXs := testSpend(wallet)
+	tx.Sign(wallet.PrivateKey, prevTXs)
+	assert.Len(t, tx.Vin[0].Signature, signatureLen)
+	assert.Equal(t, byte(SigHashAll), tx.Vin[0].Signature[64])
+	assert.True(t, tx.Verify(prevTXs))
+
+	tx.Vout[0].Value = 5
+	assert.False(t, tx.Verify(prevTXs), "Changing an output invalidates the signature")
+
+	tx.Vout[0].Value = 4
+	tx.Vin[0].Signature[64] = byte(SigHashNone)
+	assert.False(t, tx.Verify(prevTXs), "Changing the hash type invalidates the signature")
+}
+
+func TestSigHashTypes(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	spentOf := func(tx *Transaction, prevTXs map[string]Transaction, i int) TXOutput {
+		return prevTXs[hex.EncodeToString(tx.Vin[i].Txid)].Vout[0]
+	}
+
+	// Bob signs only his output, so Alice may change hers
+	tx, prevTXs := testSpend(alice, bob)
+	assert.Nil(t, alice.SignInput(tx, 0, spentOf(tx, prevTXs, 0), SigHashAll))
+	assert.Nil(t, bob.SignInput(tx, 1, spentOf(tx, prevTXs, 1), SigHashSingle))
+	assert.True(t, tx.Verify(prevTXs))
+	tx.Vout[0].Value = 3
+	assert.Nil(t, alice.SignInput(tx, 0, spentOf(tx, prevTXs, 0), SigHashAll))
+	assert.True(t, tx.Verify(prevTXs), "SigHashSingle leaves the other outputs open")
+	tx.Vout[1].Value = 3
+	assert.False(t, tx.Verify(prevTXs), "SigHashSingle covers the output of its input")
+
+	// Bob signs no outputs at all
+	tx, prevTXs = testSpend(alice, bob)
+	assert.Nil(t, bob.SignInput(tx, 1, spentOf(tx, prevTXs, 1), SigHashNone))
+	tx.Vout = tx.Vout[:1]
+	tx.Vout[0].Value = 8
+	assert.Nil(t, alice.SignInput(tx, 0, spentOf(tx, prevTXs, 0), SigHashAll))
+	assert.True(t, tx.Verify(prevTXs), "SigHashNone leaves all outputs open")
+
+	// Alice pledges her input to an output, Bob adds his input later
+	tx, prevTXs = testSpend(alice)
+	tx.Vout[0].Value = 8
+	assert.Nil(t, alice.SignInput(tx, 0, spentOf(tx, prevTXs, 0), SigHashAll|SigHashAnyoneCanPay))
+	more, morePrevTXs := testSpend(bob)
+	for txID, prevTx := range morePrevTXs {
+		prevTXs[txID] = prevTx
+	}
+	tx.Vin = append(tx.Vin, more.Vin[0])
+	assert.Nil(t, bob.SignInput(tx, 1, spentOf(tx, prevTXs, 1), SigHashAll|SigHashAnyoneCanPay))
+	assert.True(t, tx.Verify(prevTXs), "SigHashAnyoneCanPay lets others add inputs")
+	assert.Equal(t, tx.Hash(), tx.ID)
+	tx.Vout[0].Value = 9
+	assert.False(t, tx.Verify(prevTXs), "SigHashAll still covers the outputs")
+
+	// Without SigHashAnyoneCanPay the inputs are fixed
+	tx, prevTXs = testSpend(alice)
+	assert.Nil(t, alice.SignInput(tx, 0, spentOf(tx, prevTXs, 0), SigHashNone))
+	tx.Vin = append(tx.Vin, more.Vin[0])
+	for txID, prevTx := range morePrevTXs {
+		prevTXs[txID] = prevTx
+	}
+	assert.Nil(t, bob.SignInput(tx, 1, spentOf(tx, prevTXs, 1), SigHashAll))
+	assert.False(t, tx.Verify(prevTXs), "Other hash types cover all inputs")
+}
@@ -0,0 +1,44 @@
+package main
+
+// !https://blockchain.info/tx/4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b?show_adv=true
+// !subsidy 是挖出新块的奖励金。在比特币中，实际并没有存储这个数字，
+// !而是基于区块总数进行计算而得：区块总数除以 210000 就是 subsidy。
+// !挖出创世块的奖励是 50 BTC，每挖出 210000 个块后，奖励减半。
+// !在我们的实现中，这个奖励值同样根据区块高度计算，见 GetBlockSubsidy。
+
+// MaxMoney is the most value a single output, and the sum of the inputs,
+// outputs or fees of a transaction or block, may hold. It lies above the total
+// supply of every network, so that summing values never overflows.
+const MaxMoney = 21000000
+
+// GetBlockSubsidy returns the reward for mining the block at the given height
+func GetBlockSubsidy(height int) int {
+	halvings := height / activeNetParams.SubsidyHalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return activeNetParams.InitialSubsidy >> uint(halvings)
+}
+
+// TotalSupply returns the number of coins created by the subsidies of all
+// blocks up to and including the given height
+func TotalSupply(height int) int {
+	supply := 0
+	interval := activeNetParams.SubsidyHalvingInterval
+
+	for start := 0; start <= height; start += interval {
+		reward := GetBlockSubsidy(start)
+		if reward == 0 {
+			break
+		}
+
+		blocks := interval
+		if height-start+1 < blocks {
+			blocks = height - start + 1
+		}
+		supply += blocks * reward
+	}
+
+	return supply
+}
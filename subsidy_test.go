@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBlockSubsidy(t *testing.T) {
+	params := activeNetParams
+	assert.Equal(t, params.InitialSubsidy, GetBlockSubsidy(0), "Genesis pays the initial subsidy")
+	assert.Equal(t, params.InitialSubsidy, GetBlockSubsidy(params.SubsidyHalvingInterval-1), "Subsidy holds until the halving")
+	assert.Equal(t, params.InitialSubsidy/2, GetBlockSubsidy(params.SubsidyHalvingInterval), "Subsidy halves")
+	assert.Equal(t, params.InitialSubsidy/4, GetBlockSubsidy(2*params.SubsidyHalvingInterval+5), "Subsidy halves again")
+	assert.Equal(t, 0, GetBlockSubsidy(100*params.SubsidyHalvingInterval), "Subsidy runs out")
+}
+
+func TestTotalSupply(t *testing.T) {
+	params := activeNetParams
+	assert.Equal(t, params.InitialSubsidy, TotalSupply(0), "Genesis only")
+	assert.Equal(t, 3*params.InitialSubsidy, TotalSupply(2), "First blocks")
+
+	expected := params.SubsidyHalvingInterval*params.InitialSubsidy + 2*(params.InitialSubsidy/2)
+	assert.Equal(t, expected, TotalSupply(params.SubsidyHalvingInterval+1), "Supply across a halving")
+
+	final := TotalSupply(100 * params.SubsidyHalvingInterval)
+	assert.Equal(t, final, TotalSupply(200*params.SubsidyHalvingInterval), "Supply is capped")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAllowedOffset is the largest clock offset in seconds the peers may
+// apply to the local clock. A larger median offset is ignored, since it more
+// likely means the local clock or the peers are broken.
+const maxAllowedOffset = 70 * 60
+
+// maxTimeSamples bounds the number of peers whose clock offset is kept
+const maxTimeSamples = 200
+
+// MedianTime provides the adjusted network time: the local clock corrected
+// by the median offset between it and the clocks of the peers
+type MedianTime struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+// timeSource is the node's view of the network time
+var timeSource = NewMedianTime()
+
+// NewMedianTime creates a MedianTime without any peer samples
+func NewMedianTime() *MedianTime {
+	return &MedianTime{offsets: make(map[string]int64)}
+}
+
+// AddTimeSample records the time a peer reported in its version message.
+// Every peer contributes a single sample.
+func (m *MedianTime) AddTimeSample(source string, timestamp int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.offsets[source]; !ok && len(m.offsets) >= maxTimeSamples {
+		return
+	}
+
+	m.offsets[source] = timestamp - time.Now().Unix()
+}
+
+// Offset returns the median of the peer offsets, counting the local clock as
+// a sample with no offset
+func (m *MedianTime) Offset() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	offsets := []int64{0}
+	for _, offset := range m.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	median := offsets[len(offsets)/2]
+	if median > maxAllowedOffset || median < -maxAllowedOffset {
+		log.Printf("Median peer clock offset of %d seconds is too large, check your clock\n", median)
+		return 0
+	}
+
+	return median
+}
+
+// AdjustedTime returns the current network time as a Unix timestamp
+func (m *MedianTime) AdjustedTime() int64 {
+	return time.Now().Unix() + m.Offset()
+}
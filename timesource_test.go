@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedianTimeOffset(t *testing.T) {
+	m := NewMedianTime()
+	assert.Equal(t, int64(0), m.Offset(), "No samples means no offset")
+
+	now := time.Now().Unix()
+	m.AddTimeSample("a", now+100)
+	m.AddTimeSample("b", now+120)
+	offset := m.Offset()
+	assert.True(t, offset >= 99 && offset <= 101, "Median of 0, 100 and 120 is 100")
+
+	m.AddTimeSample("a", now+5000)
+	m.AddTimeSample("a", now+5000)
+	offset = m.Offset()
+	assert.True(t, offset >= 119 && offset <= 121, "A peer contributes a single sample")
+
+	m.AddTimeSample("c", now+maxAllowedOffset+10)
+	m.AddTimeSample("d", now+maxAllowedOffset+10)
+	assert.Equal(t, int64(0), m.Offset(), "Offsets beyond the limit are ignored")
+}
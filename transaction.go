@@ -3,10 +3,8 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"math/big"
 
 	"encoding/gob"
 	"encoding/hex"
@@ -15,13 +13,14 @@ import (
 	"strings"
 )
 
-const subsidy = 10
-
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
 	ID   []byte
 	Vin  []TXInput
 	Vout []TXOutput
+	// LockTime is the height or, from lockTimeThreshold on, the time before
+	// which the transaction cannot be included in a block
+	LockTime int64
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -43,18 +42,26 @@ func (tx Transaction) Serialize() []byte {
 }
 
 // Hash returns the hash of the Transaction
+// Signatures are left out, so that re-encoding a signature cannot change
+// the ID; signatures cover the signature hash instead
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		vin.Signature = nil
+		vin.ScriptSig = nil
+		txCopy.Vin[i] = vin
+	}
 
 	hash = sha256.Sum256(txCopy.Serialize())
 
 	return hash[:]
 }
 
-// Sign signs each input of a Transaction
+// Sign signs each input of a Transaction with SigHashAll
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
@@ -66,24 +73,50 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		err := tx.SignInput(privKey, inID, prevTx.Vout[vin.Vout], SigHashAll)
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+	}
+}
 
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+// SignInput signs the input at inIndex, which spends the output spent, over
+// the signature hash of the hash type. The hash type is appended to the
+// signature.
+func (tx *Transaction) SignInput(privKey ecdsa.PrivateKey, inIndex int, spent TXOutput, hashType SigHashType) error {
+	signature, err := tx.InputSignature(privKey, inIndex, spent, hashType)
+	if err != nil {
+		return err
 	}
+
+	tx.Vin[inIndex].Signature = signature
+
+	return nil
+}
+
+// InputSignature returns the signature of the input at inIndex, which spends
+// the output spent, over the signature hash of the hash type, for use in a
+// signature script
+func (tx *Transaction) InputSignature(privKey ecdsa.PrivateKey, inIndex int, spent TXOutput, hashType SigHashType) ([]byte, error) {
+	sighash, err := tx.SignatureHash(inIndex, spent, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, sighash)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := make([]byte, signatureLen)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:64])
+	signature[64] = byte(hashType)
+
+	return signature, nil
 }
 
 // String returns a human-readable representation of a transaction
@@ -99,12 +132,22 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if len(input.ScriptSig) > 0 {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", DisasmScript(input.ScriptSig)))
+		}
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %#x", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", DisasmScript(output.LockingScript())))
+	}
+
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
 	}
 
 	return strings.Join(lines, "\n")
@@ -116,19 +159,20 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{Txid: vin.Txid, Vout: vin.Vout, Sequence: vin.Sequence})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash, vout.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.LockTime}
 
 	return txCopy
 }
 
-// Verify verifies signatures of Transaction inputs
+// Verify verifies that each input of the Transaction unlocks the output it
+// spends: its unlocking script runs followed by the output's locking script
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
@@ -140,40 +184,31 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+		spent := prevTx.Vout[vin.Vout]
 
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
+		err := VerifyScript(vin.UnlockingScript(), spent.LockingScript(), tx, inID, spent)
+		if err != nil {
 			return false
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) *Transaction {
+// NewCoinbaseTX creates a new coinbase transaction paying the subsidy of the
+// block at the given height plus the fees of the block's transactions
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
+	return NewCoinbaseTXWithValue(to, data, GetBlockSubsidy(height)+fees)
+}
+
+// NewCoinbaseTXWithValue creates a new coinbase transaction paying value to
+// the address. Random data is used when data is empty.
+func NewCoinbaseTXWithValue(to, data string, value int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -184,23 +219,25 @@ func NewCoinbaseTX(to, data string) *Transaction {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	txin := TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte(data)}
+	txout := NewTXOutput(value, to)
+	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}, 0}
 	tx.ID = tx.Hash()
 
 	return &tx
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
+// NewUTXOTransaction creates a new transaction sending amount to the address.
+// The inputs cover the amount plus the fee, which is whatever is left over
+// after the outputs and goes to the miner of the block.
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, UTXOSet *UTXOSet) *Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("ERROR: Not enough funds")
 	}
 
@@ -212,7 +249,7 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 		}
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
+			input := TXInput{Txid: txID, Vout: out, PubKey: wallet.PublicKey}
 			inputs = append(inputs, input)
 		}
 	}
@@ -220,26 +257,56 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 	// Build a list of outputs
 	from := fmt.Sprintf("%s", wallet.GetAddress())
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from)) // a change
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, inputs, outputs, 0}
 	tx.ID = tx.Hash()
-	UTXOSet.BlockChain.SignTransaction(&tx, wallet.PrivateKey)
+	err := UTXOSet.BlockChain.SignTransaction(&tx, wallet.PrivateKey)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return &tx
 }
 
+// NewUTXOTransactionWithFeeRate creates a new transaction sending amount to
+// the address and paying at least feeRate per 1000 bytes of the transaction
+func NewUTXOTransactionWithFeeRate(wallet *Wallet, to string, amount, feeRate int, UTXOSet *UTXOSet) *Transaction {
+	fee := 0
+
+	for {
+		tx := NewUTXOTransaction(wallet, to, amount, fee, UTXOSet)
+
+		required := (len(tx.Serialize())*feeRate + 999) / 1000
+		if fee >= required {
+			return tx
+		}
+		fee = required
+	}
+}
+
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) Transaction {
+	transaction, err := decodeTransaction(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return transaction
+}
+
+// decodeTransaction deserializes a transaction received from a peer or a
+// miner, which may be malformed
+func decodeTransaction(data []byte) (Transaction, error) {
 	var transaction Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&transaction)
 	if err != nil {
-		log.Panic(err)
+		return Transaction{}, fmt.Errorf("decoding transaction: %s", err)
 	}
 
-	return transaction
+	return transaction, nil
 }
@@ -2,12 +2,17 @@ package main
 
 import "bytes"
 
-// TXInput represents a transaction input
+// TXInput represents a transaction input. Inputs spending a
+// pay-to-pubkey-hash output may leave ScriptSig empty and set Signature and
+// PubKey instead, other inputs unlock their output with ScriptSig.
 type TXInput struct {
 	Txid      []byte
 	Vout      int
 	Signature []byte
 	PubKey    []byte
+	ScriptSig []byte
+	// Sequence holds the relative lock time of the input
+	Sequence uint32
 }
 
 // UsesKey checks whether the address initiated the transaction
@@ -16,3 +21,14 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
+
+// UnlockingScript returns the script that unlocks the output the input
+// spends: ScriptSig, or a signature script of Signature and PubKey when it
+// is empty
+func (in *TXInput) UnlockingScript() []byte {
+	if len(in.ScriptSig) > 0 {
+		return in.ScriptSig
+	}
+
+	return SignatureScript(in.Signature, in.PubKey)
+}
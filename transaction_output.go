@@ -6,35 +6,73 @@ import (
 	"log"
 )
 
-// TXOutput represents a transaction output
+// TXOutput represents a transaction output. Outputs paying to a public key
+// hash may leave ScriptPubKey empty and set PubKeyHash instead, other outputs
+// are locked by ScriptPubKey.
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	PubKeyHash   []byte
+	ScriptPubKey []byte
 }
 
-// Lock signs the output
+// Lock signs the output. Pubkey hash addresses set PubKeyHash, script hash
+// addresses a pay-to-script-hash ScriptPubKey.
 func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	version, hash, _ := decodeAddress(string(address))
+	if version == activeNetParams.ScriptAddressVersion {
+		out.ScriptPubKey = PayToScriptHashScript(hash)
+		return
+	}
+
+	out.PubKeyHash = hash
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
+	if len(out.ScriptPubKey) > 0 {
+		return bytes.Equal(extractPubKeyHash(out.ScriptPubKey), pubKeyHash)
+	}
+
 	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
 }
 
+// LockingScript returns the script that locks the output: ScriptPubKey, or
+// a pay-to-pubkey-hash script of PubKeyHash when it is empty
+func (out *TXOutput) LockingScript() []byte {
+	if len(out.ScriptPubKey) > 0 {
+		return out.ScriptPubKey
+	}
+
+	return PayToPubKeyHashScript(out.PubKeyHash)
+}
+
 // NewTXOutput create a new TXOutput
 func NewTXOutput(value int, address string) *TXOutput {
-	txo := &TXOutput{value, nil}
+	txo := &TXOutput{Value: value}
 	txo.Lock([]byte(address))
 
 	return txo
 }
 
-// TXOutputs collects TXOutput
+// NewScriptOutput creates a new TXOutput locked by the script
+func NewScriptOutput(value int, script []byte) *TXOutput {
+	return &TXOutput{Value: value, ScriptPubKey: script}
+}
+
+// TXOutputs collects the unspent outputs of a transaction keyed by their
+// index in the transaction, along with the height and timestamp of the block
+// that created them and whether they come from a coinbase
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs    map[int]TXOutput
+	Height     int
+	IsCoinbase bool
+	Timestamp  int64
+}
+
+// IsMature reports whether the outputs can be spent in a block at the given
+// height. Coinbase outputs need CoinbaseMaturity confirmations first.
+func (outs TXOutputs) IsMature(height int) bool {
+	return !outs.IsCoinbase || height-outs.Height >= activeNetParams.CoinbaseMaturity
 }
 
 // Serialize serializes TXOutputs
@@ -60,5 +98,9 @@ func DeserializeOutputs(data []byte) TXOutputs {
 		log.Panic(err)
 	}
 
+	if outputs.Outputs == nil {
+		outputs.Outputs = make(map[int]TXOutput)
+	}
+
 	return outputs
 }
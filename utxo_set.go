@@ -1,13 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/boltdb/bolt"
 )
 
 const utxoBucket = "chainstate"
+const undoBucket = "undo"
 
 // UTXOSet represents UTXO set
 type UTXOSet struct {
@@ -15,9 +18,18 @@ type UTXOSet struct {
 }
 
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs
+// Coinbase outputs that would not be mature in the next block are skipped
 func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int) {
+	return u.FindSpendableScriptOutputs(PayToPubKeyHashScript(pubkeyHash), amount)
+}
+
+// FindSpendableScriptOutputs finds unspent outputs locked by the script
+// worth at least amount, skipping immature coinbase outputs like
+// FindSpendableOutputs
+func (u UTXOSet) FindSpendableScriptOutputs(script []byte, amount int) (int, map[string][]int) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
+	nextHeight := u.BlockChain.GetBestHeight() + 1
 	db := u.BlockChain.db
 	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
@@ -27,8 +39,12 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
 
+			if !outs.IsMature(nextHeight) {
+				continue
+			}
+
 			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
+				if bytes.Equal(out.LockingScript(), script) && accumulated < amount {
 					accumulated += out.Value
 					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
 				}
@@ -46,6 +62,11 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 
 // FindUTXO finds UTXO for a public key hash
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
+	return u.FindScriptUTXO(PayToPubKeyHashScript(pubKeyHash))
+}
+
+// FindScriptUTXO finds UTXO locked by the script
+func (u UTXOSet) FindScriptUTXO(script []byte) []TXOutput {
 	var UTXOs []TXOutput
 	db := u.BlockChain.db
 
@@ -57,7 +78,7 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 			outs := DeserializeOutputs(v)
 
 			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
+				if bytes.Equal(out.LockingScript(), script) {
 					UTXOs = append(UTXOs, out)
 				}
 			}
@@ -72,17 +93,37 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
-// CountTransactions returns the number of transactions in the UTXO set
-func (u UTXOSet) CountTransactions() int {
+// Stake is an unspent output that can be staked, with the timestamp of the
+// block that created it
+type Stake struct {
+	Txid      []byte
+	Vout      int
+	Output    TXOutput
+	Timestamp int64
+}
+
+// FindStakes finds the mature unspent outputs of a public key hash
+func (u UTXOSet) FindStakes(pubKeyHash []byte) []Stake {
+	var stakes []Stake
+	nextHeight := u.BlockChain.GetBestHeight() + 1
 	db := u.BlockChain.db
-	counter := 0
 
 	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			counter++
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeserializeOutputs(v)
+
+			if !outs.IsMature(nextHeight) {
+				continue
+			}
+
+			for outIdx, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					stakes = append(stakes, Stake{append([]byte{}, k...), outIdx, out, outs.Timestamp})
+				}
+			}
 		}
 
 		return nil
@@ -91,23 +132,73 @@ func (u UTXOSet) CountTransactions() int {
 		log.Panic(err)
 	}
 
-	return counter
+	return stakes
 }
 
-// Reindex rebuilds the UTXO set
-func (u UTXOSet) Reindex() {
+// CalcFee returns the fee paid by the transaction: the value of the outputs
+// it spends minus the value of the outputs it creates. The spent outputs must
+// be in the UTXO set.
+func (u UTXOSet) CalcFee(transaction *Transaction) (int, error) {
+	if transaction.IsCoinbase() {
+		return 0, nil
+	}
+
+	inputValue := 0
 	db := u.BlockChain.db
-	bucketName := []byte(utxoBucket)
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		for _, vin := range transaction.Vin {
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes == nil {
+				return fmt.Errorf("output %x:%d is not in the UTXO set", vin.Txid, vin.Vout)
+			}
+
+			out, ok := DeserializeOutputs(outsBytes).Outputs[vin.Vout]
+			if !ok {
+				return fmt.Errorf("output %x:%d is not in the UTXO set", vin.Txid, vin.Vout)
+			}
+			inputValue += out.Value
+			if inputValue > MaxMoney {
+				return fmt.Errorf("inputs of transaction %x add up to more than %d", transaction.ID, MaxMoney)
+			}
 		}
 
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			log.Panic(err)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	outputValue := 0
+	for _, out := range transaction.Vout {
+		if out.Value < 0 || out.Value > MaxMoney {
+			return 0, fmt.Errorf("output of transaction %x has the value %d out of range", transaction.ID, out.Value)
+		}
+		outputValue += out.Value
+		if outputValue > MaxMoney {
+			return 0, fmt.Errorf("outputs of transaction %x add up to more than %d", transaction.ID, MaxMoney)
+		}
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// SpendsImmatureCoinbase reports whether the transaction spends a coinbase
+// output that cannot be spent in a block at the given height yet
+func (u UTXOSet) SpendsImmatureCoinbase(transaction *Transaction, height int) bool {
+	immature := false
+	db := u.BlockChain.db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		for _, vin := range transaction.Vin {
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes != nil && !DeserializeOutputs(outsBytes).IsMature(height) {
+				immature = true
+			}
 		}
 
 		return nil
@@ -116,25 +207,96 @@ func (u UTXOSet) Reindex() {
 		log.Panic(err)
 	}
 
-	UTXO := u.BlockChain.FindUTXO()
+	return immature
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+// SequenceLocked reports whether an input of the transaction spends an
+// output before its relative lock time allows in a block at the given height
+// whose parent has the median time
+func (u UTXOSet) SequenceLocked(transaction *Transaction, height int, medianTime int64) bool {
+	locked := false
+	db := u.BlockChain.db
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
-			if err != nil {
-				log.Panic(err)
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		for _, vin := range transaction.Vin {
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes == nil {
+				continue
 			}
 
-			err = b.Put(key, outs.Serialize())
-			if err != nil {
-				log.Panic(err)
+			outs := DeserializeOutputs(outsBytes)
+			if !sequenceLockSatisfied(vin.Sequence, outs.Height, outs.Timestamp, height, medianTime) {
+				locked = true
 			}
 		}
 
 		return nil
 	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return locked
+}
+
+// CountTransactions returns the number of transactions in the UTXO set
+func (u UTXOSet) CountTransactions() int {
+	db := u.BlockChain.db
+	counter := 0
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			counter++
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return counter
+}
+
+// Reindex rebuilds the UTXO set by connecting the blocks of the chain from
+// the genesis block on, which also records their undo data, and marks it
+// with the current chainstate version
+func (u UTXOSet) Reindex() {
+	db := u.BlockChain.db
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucketName := range []string{utxoBucket, undoBucket} {
+			err := tx.DeleteBucket([]byte(bucketName))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		_, err := tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		var chain [][]byte
+		for hash := b.Get([]byte("l")); len(hash) > 0; hash = DeserializeBlock(b.Get(hash)).PrevBlockHash {
+			chain = append(chain, hash)
+		}
+
+		for i := len(chain) - 1; i >= 0; i-- {
+			u.update(tx, DeserializeBlock(b.Get(chain[i])))
+		}
+
+		return b.Put([]byte(chainstateVersionKey), []byte{chainstateVersion})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
 }
 
 // Update updates the UTXO set with transactions from the Block
@@ -143,50 +305,129 @@ func (u UTXOSet) Update(block *Block) {
 	db := u.BlockChain.db
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+		u.update(tx, block)
 
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
+// Disconnect reverts the changes the Block made to the UTXO set using the
+// undo data recorded when it was connected
+// The Block is considered to be the tip of a blockchain
+func (u UTXOSet) Disconnect(block *Block) error {
+	return u.BlockChain.db.Update(func(tx *bolt.Tx) error {
+		return u.disconnect(tx, block)
+	})
+}
 
+// update spends the outputs referenced by the block's inputs, adds the
+// outputs it creates and records undo data within an open DB transaction
+func (u UTXOSet) update(tx *bolt.Tx, block *Block) {
+	b := tx.Bucket([]byte(utxoBucket))
+	undo := BlockUndo{}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, vin := range tx.Vin {
+				outs := DeserializeOutputs(b.Get(vin.Txid))
+				spent := SpentOutput{vin.Txid, vin.Vout, outs.Outputs[vin.Vout], outs.Height, outs.IsCoinbase, outs.Timestamp}
+				undo.SpentOutputs = append(undo.SpentOutputs, spent)
+				delete(outs.Outputs, vin.Vout)
+
+				if len(outs.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						log.Panic(err)
+					}
+				} else {
+					err := b.Put(vin.Txid, outs.Serialize())
+					if err != nil {
+						log.Panic(err)
+					}
 				}
 			}
+		}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+		newOutputs := TXOutputs{make(map[int]TXOutput), block.Height, tx.IsCoinbase(), block.Timestamp}
+		for outIdx, out := range tx.Vout {
+			if !isUnspendable(out.LockingScript()) {
+				newOutputs.Outputs[outIdx] = out
 			}
+		}
+		if len(newOutputs.Outputs) == 0 {
+			continue
+		}
+
+		err := b.Put(tx.ID, newOutputs.Serialize())
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	undoB, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		log.Panic(err)
+	}
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
+	err = undoB.Put(block.Hash, undo.Serialize())
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// disconnect removes the outputs created by the block and restores the
+// outputs it spent within an open DB transaction. Transactions are undone in
+// reverse order, so outputs created and spent inside the block vanish.
+// It fails when no undo data was recorded for the block.
+func (u UTXOSet) disconnect(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	undoB := tx.Bucket([]byte(undoBucket))
+
+	var undoData []byte
+	if undoB != nil {
+		undoData = undoB.Get(block.Hash)
+	}
+	if undoData == nil {
+		return fmt.Errorf("no undo data for block %x", block.Hash)
+	}
+	spent := DeserializeBlockUndo(undoData).SpentOutputs
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		transaction := block.Transactions[i]
+
+		err := b.Delete(transaction.ID)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		if transaction.IsCoinbase() {
+			continue
+		}
+
+		for range transaction.Vin {
+			restored := spent[len(spent)-1]
+			spent = spent[:len(spent)-1]
+
+			outs := TXOutputs{make(map[int]TXOutput), restored.Height, restored.IsCoinbase, restored.Timestamp}
+			if outsBytes := b.Get(restored.Txid); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
+			}
+			outs.Outputs[restored.Vout] = restored.Output
+
+			err = b.Put(restored.Txid, outs.Serialize())
 			if err != nil {
 				log.Panic(err)
 			}
 		}
+	}
 
-		return nil
-	})
+	err := undoB.Delete(block.Hash)
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return nil
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateDisconnect(t *testing.T) {
+	alice, bob, carol := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestBlockChain(t, string(alice.GetAddress()))
+	UTXOSet := UTXOSet{bc}
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	before := utxoSnapshot(t, bc)
+
+	// Bob receives the genesis coinbase and passes it on to Carol in the
+	// same block
+	coinbase := genesis.Transactions[0]
+	toBob := &Transaction{Vin: []TXInput{{Txid: coinbase.ID, Vout: 0}}, Vout: []TXOutput{*NewTXOutput(coinbase.Vout[0].Value, string(bob.GetAddress()))}}
+	toBob.ID = toBob.Hash()
+	toCarol := &Transaction{Vin: []TXInput{{Txid: toBob.ID, Vout: 0}}, Vout: []TXOutput{*NewTXOutput(coinbase.Vout[0].Value, string(carol.GetAddress()))}}
+	toCarol.ID = toCarol.Hash()
+
+	cbTx := NewCoinbaseTX(string(alice.GetAddress()), "", 1, 0)
+	block := NewBlock([]*Transaction{cbTx, toBob, toCarol}, genesis.Hash, 1, activeNetParams.GenesisBits, genesis.Timestamp+1)
+
+	UTXOSet.Update(block)
+	assert.Len(t, UTXOSet.FindUTXO(HashPubKey(alice.PublicKey)), 1)
+	assert.Len(t, UTXOSet.FindUTXO(HashPubKey(bob.PublicKey)), 0)
+	assert.Len(t, UTXOSet.FindUTXO(HashPubKey(carol.PublicKey)), 1)
+
+	assert.Nil(t, UTXOSet.Disconnect(block))
+	assert.Equal(t, before, utxoSnapshot(t, bc), "Disconnecting restores the UTXO set")
+
+	assert.NotNil(t, UTXOSet.Disconnect(block), "The undo data is gone")
+	assert.Equal(t, before, utxoSnapshot(t, bc))
+}
+
+func TestCalcFeeMoneyRange(t *testing.T) {
+	alice := NewWallet()
+	bc := newTestBlockChain(t, string(alice.GetAddress()))
+	UTXOSet := UTXOSet{bc}
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+
+	tx := &Transaction{nil, []TXInput{{Txid: coinbase.ID, Vout: 0}}, []TXOutput{*NewTXOutput(1, string(alice.GetAddress()))}, 0}
+	fee, err := UTXOSet.CalcFee(tx)
+	assert.Nil(t, err)
+	assert.Equal(t, coinbase.Vout[0].Value-1, fee)
+
+	tx.Vout[0].Value = math.MaxInt
+	tx.Vout = append(tx.Vout, tx.Vout[0])
+	_, err = UTXOSet.CalcFee(tx)
+	assert.NotNil(t, err, "Outputs whose sum overflows")
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// medianTimeBlocks is the number of previous blocks whose median timestamp a
+// new block's timestamp must exceed
+const medianTimeBlocks = 11
+
+// maxFutureBlockTime is how many seconds a block's timestamp may be ahead of
+// the adjusted network time
+const maxFutureBlockTime = 10 * 60
+
+// RejectCode identifies the rule a block or transaction broke
+type RejectCode int
+
+const (
+	RejectBadHash RejectCode = iota
+	RejectBadPoW
+	RejectBadBits
+	RejectBadMerkleRoot
+	RejectNoTransactions
+	RejectBadCoinbase
+	RejectBadTransaction
+	RejectDuplicateTx
+	RejectOrphan
+	RejectBadHeight
+	RejectMissingInput
+	RejectDoubleSpend
+	RejectBadSignature
+	RejectBadCoinbaseValue
+	RejectBadFee
+	RejectImmatureCoinbase
+	RejectTimeTooOld
+	RejectTimeTooNew
+	RejectCheckpoint
+	RejectForkBeforeCheckpoint
+	RejectBadSigner
+	RejectBadStake
+	RejectUnauthorizedMinter
+	RejectMinterRateLimit
+	RejectNonFinal
+	RejectBadHeader
+)
+
+var rejectCodeNames = map[RejectCode]string{
+	RejectBadHash:              "bad-hash",
+	RejectBadPoW:               "bad-pow",
+	RejectBadBits:              "bad-bits",
+	RejectBadMerkleRoot:        "bad-merkle-root",
+	RejectNoTransactions:       "no-transactions",
+	RejectBadCoinbase:          "bad-coinbase",
+	RejectBadTransaction:       "bad-transaction",
+	RejectDuplicateTx:          "duplicate-tx",
+	RejectOrphan:               "orphan",
+	RejectBadHeight:            "bad-height",
+	RejectMissingInput:         "missing-input",
+	RejectDoubleSpend:          "double-spend",
+	RejectBadSignature:         "bad-signature",
+	RejectBadCoinbaseValue:     "bad-coinbase-value",
+	RejectBadFee:               "bad-fee",
+	RejectImmatureCoinbase:     "immature-coinbase",
+	RejectTimeTooOld:           "time-too-old",
+	RejectTimeTooNew:           "time-too-new",
+	RejectCheckpoint:           "checkpoint-mismatch",
+	RejectForkBeforeCheckpoint: "fork-before-checkpoint",
+	RejectBadSigner:            "bad-signer",
+	RejectBadStake:             "bad-stake",
+	RejectUnauthorizedMinter:   "unauthorized-minter",
+	RejectMinterRateLimit:      "minter-rate-limit",
+	RejectNonFinal:             "non-final",
+	RejectBadHeader:            "bad-header",
+}
+
+// String returns a short name of the reject code
+func (code RejectCode) String() string {
+	if name, ok := rejectCodeNames[code]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", int(code))
+}
+
+// RuleError describes why a block or transaction was rejected
+type RuleError struct {
+	Code        RejectCode
+	Description string
+}
+
+func (e RuleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func ruleError(code RejectCode, format string, args ...interface{}) RuleError {
+	return RuleError{code, fmt.Sprintf(format, args...)}
+}
+
+// CheckBlock checks the rules a block must follow regardless of the chain it
+// is attached to
+func CheckBlock(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(RejectNoTransactions, "block %x has no transactions", block.Hash)
+	}
+
+	err := block.BlockHeader.checkHashLengths()
+	if err != nil {
+		return ruleError(RejectBadHeader, "block %x: %s", block.Hash, err)
+	}
+
+	if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+		return ruleError(RejectBadHash, "block hash %x does not match its header", block.Hash)
+	}
+
+	err = activeConsensus.VerifySeal(block)
+	if err != nil {
+		return err
+	}
+
+	maxTimestamp := timeSource.AdjustedTime() + maxFutureBlockTime
+	if block.Timestamp > maxTimestamp {
+		return ruleError(RejectTimeTooNew, "block %x has timestamp %d, more than %d seconds ahead of the network time", block.Hash, block.Timestamp, maxFutureBlockTime)
+	}
+
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(RejectBadCoinbase, "first transaction of block %x is not a coinbase", block.Hash)
+	}
+
+	txIDs := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(RejectBadCoinbase, "block %x has more than one coinbase", block.Hash)
+		}
+
+		err := CheckTransactionSanity(tx)
+		if err != nil {
+			return err
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if txIDs[txID] {
+			return ruleError(RejectDuplicateTx, "transaction %s appears twice in block %x", txID, block.Hash)
+		}
+		txIDs[txID] = true
+	}
+
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ruleError(RejectBadMerkleRoot, "merkle root of block %x does not match its transactions", block.Hash)
+	}
+
+	return nil
+}
+
+// CheckTransactionSanity checks the rules a transaction must follow
+// regardless of the outputs it spends
+func CheckTransactionSanity(tx *Transaction) error {
+	if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+		return ruleError(RejectBadTransaction, "transaction %x has no inputs or outputs", tx.ID)
+	}
+
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return ruleError(RejectBadTransaction, "transaction ID %x does not match its hash", tx.ID)
+	}
+
+	outputValue := 0
+	for _, out := range tx.Vout {
+		if out.Value < 0 {
+			return ruleError(RejectBadTransaction, "transaction %x has a negative output", tx.ID)
+		}
+		if out.Value > MaxMoney {
+			return ruleError(RejectBadTransaction, "transaction %x has an output of %d, more than %d", tx.ID, out.Value, MaxMoney)
+		}
+		outputValue += out.Value
+		if outputValue > MaxMoney {
+			return ruleError(RejectBadTransaction, "outputs of transaction %x add up to more than %d", tx.ID, MaxMoney)
+		}
+		if len(out.ScriptPubKey) > maxScriptSize {
+			return ruleError(RejectBadTransaction, "transaction %x has an output script larger than %d bytes", tx.ID, maxScriptSize)
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	spent := make(map[string]bool)
+	for _, vin := range tx.Vin {
+		if len(vin.ScriptSig) > maxScriptSize {
+			return ruleError(RejectBadTransaction, "transaction %x has an input script larger than %d bytes", tx.ID, maxScriptSize)
+		}
+
+		outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if spent[outpoint] {
+			return ruleError(RejectDoubleSpend, "transaction %x spends %s twice", tx.ID, outpoint)
+		}
+		spent[outpoint] = true
+	}
+
+	return nil
+}
+
+// ValidateBlock checks the block against the consensus rules without adding
+// it to the chain. The transactions of a block extending the tip are checked
+// against the UTXO set; blocks on side branches get that check when their
+// branch is connected.
+func (bc *BlockChain) ValidateBlock(block *Block) error {
+	return bc.db.View(func(tx *bolt.Tx) error {
+		err := CheckBlock(block)
+		if err != nil {
+			return err
+		}
+
+		err = checkBlockContext(tx, block)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		if bytes.Equal(block.PrevBlockHash, b.Get([]byte("l"))) {
+			return checkBlockTransactions(tx, block)
+		}
+
+		return nil
+	})
+}
+
+// checkBlockContext checks the header of the block against its parent
+func checkBlockContext(tx *bolt.Tx, block *Block) error {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	parentData := b.Get(block.PrevBlockHash)
+	if len(block.PrevBlockHash) == 0 || parentData == nil {
+		return ruleError(RejectOrphan, "parent %x of block %x is unknown", block.PrevBlockHash, block.Hash)
+	}
+	parent := DeserializeBlock(parentData)
+
+	if block.Height != parent.Height+1 {
+		return ruleError(RejectBadHeight, "block %x has height %d, expected %d", block.Hash, block.Height, parent.Height+1)
+	}
+
+	checkpoint := activeNetParams.CheckpointAt(block.Height)
+	if checkpoint != nil && checkpoint.Hash != hex.EncodeToString(block.Hash) {
+		return ruleError(RejectCheckpoint, "block %x at height %d conflicts with checkpoint %s", block.Hash, block.Height, checkpoint.Hash)
+	}
+
+	tip := DeserializeBlock(b.Get(b.Get([]byte("l"))))
+	checkpoint = activeNetParams.LastCheckpoint(tip.Height)
+	if checkpoint != nil && block.Height < checkpoint.Height {
+		return ruleError(RejectForkBeforeCheckpoint, "block %x at height %d forks off below checkpoint %d", block.Hash, block.Height, checkpoint.Height)
+	}
+
+	medianTime := medianTimePast(tx, parent)
+	if block.Timestamp <= medianTime {
+		return ruleError(RejectTimeTooOld, "block %x has timestamp %d, not after the median time %d of the previous blocks", block.Hash, block.Timestamp, medianTime)
+	}
+
+	requiredBits := activeConsensus.RequiredBits(tx, parent)
+	if block.Bits != requiredBits {
+		return ruleError(RejectBadBits, "block %x has bits %08x, expected %08x", block.Hash, block.Bits, requiredBits)
+	}
+
+	return nil
+}
+
+// checkBlockTransactions checks the transactions of the block against the
+// UTXO set, which must be at the state of the block's parent: every input
+// must spend an existing, mature unspent output exactly once with a valid
+// signature, transactions and inputs must be past their lock times, no
+// transaction may create more value than it spends, and the
+// coinbase may not claim more than the subsidy plus the fees. In
+// proof-of-stake blocks the coinstake claims them instead. Signatures are not
+// checked in the ancestors of a checkpoint when skipCheckpointedSignatures is
+// set.
+func checkBlockTransactions(tx *bolt.Tx, block *Block) error {
+	err := activeConsensus.VerifyState(tx, block)
+	if err != nil {
+		return err
+	}
+
+	parent := DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(block.PrevBlockHash))
+	medianTime := medianTimePast(tx, parent)
+
+	b := tx.Bucket([]byte(utxoBucket))
+	blockTXs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	coinstake := block.Coinstake()
+	fees := 0
+	minted := 0
+
+	for _, transaction := range block.Transactions {
+		if !transaction.IsFinal(block.Height, medianTime) {
+			return ruleError(RejectNonFinal, "transaction %x is locked until %d", transaction.ID, transaction.LockTime)
+		}
+
+		if transaction.IsCoinbase() {
+			blockTXs[hex.EncodeToString(transaction.ID)] = *transaction
+			continue
+		}
+
+		prevTXs := make(map[string]Transaction)
+		inputValue := 0
+
+		for _, vin := range transaction.Vin {
+			txID := hex.EncodeToString(vin.Txid)
+			outpoint := fmt.Sprintf("%s:%d", txID, vin.Vout)
+			if spent[outpoint] {
+				return ruleError(RejectDoubleSpend, "output %s is spent twice in block %x", outpoint, block.Hash)
+			}
+			spent[outpoint] = true
+
+			var out TXOutput
+			prevHeight, prevTime := block.Height, block.Timestamp
+			if prevTx, ok := blockTXs[txID]; ok {
+				if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+					return ruleError(RejectMissingInput, "transaction %x spends unknown output %s", transaction.ID, outpoint)
+				}
+				if prevTx.IsCoinbase() {
+					return ruleError(RejectImmatureCoinbase, "transaction %x spends the coinbase of its own block", transaction.ID)
+				}
+				out = prevTx.Vout[vin.Vout]
+				prevTXs[txID] = prevTx
+			} else {
+				outsData := b.Get(vin.Txid)
+				if outsData == nil {
+					return ruleError(RejectMissingInput, "transaction %x spends unknown output %s", transaction.ID, outpoint)
+				}
+
+				outs := DeserializeOutputs(outsData)
+				unspent, ok := outs.Outputs[vin.Vout]
+				if !ok {
+					return ruleError(RejectMissingInput, "transaction %x spends unknown output %s", transaction.ID, outpoint)
+				}
+				if !outs.IsMature(block.Height) {
+					return ruleError(RejectImmatureCoinbase, "transaction %x spends coinbase output %s from height %d", transaction.ID, outpoint, outs.Height)
+				}
+				out = unspent
+				prevHeight, prevTime = outs.Height, outs.Timestamp
+
+				if _, ok := prevTXs[txID]; !ok {
+					prevTx, err := findTransaction(tx, block.PrevBlockHash, vin.Txid)
+					if err != nil {
+						return ruleError(RejectMissingInput, "transaction %s is not found", txID)
+					}
+					prevTXs[txID] = *prevTx
+				}
+			}
+
+			if !sequenceLockSatisfied(vin.Sequence, prevHeight, prevTime, block.Height, medianTime) {
+				return ruleError(RejectNonFinal, "transaction %x spends output %s before its relative lock time", transaction.ID, outpoint)
+			}
+
+			inputValue += out.Value
+			if inputValue > MaxMoney {
+				return ruleError(RejectBadTransaction, "inputs of transaction %x add up to more than %d", transaction.ID, MaxMoney)
+			}
+		}
+
+		if verifiesSignatures(tx, block) && !transaction.Verify(prevTXs) {
+			return ruleError(RejectBadSignature, "transaction %x has an invalid signature", transaction.ID)
+		}
+
+		outputValue := 0
+		for _, out := range transaction.Vout {
+			outputValue += out.Value
+		}
+		if transaction == coinstake && outputValue > inputValue {
+			minted = outputValue - inputValue
+		} else if outputValue > inputValue {
+			return ruleError(RejectBadFee, "outputs of transaction %x exceed its inputs by %d", transaction.ID, outputValue-inputValue)
+		} else {
+			fees += inputValue - outputValue
+			if fees > MaxMoney {
+				return ruleError(RejectBadFee, "fees of block %x add up to more than %d", block.Hash, MaxMoney)
+			}
+		}
+
+		blockTXs[hex.EncodeToString(transaction.ID)] = *transaction
+	}
+
+	coinbaseValue := 0
+	for _, out := range block.Transactions[0].Vout {
+		coinbaseValue += out.Value
+	}
+	subsidy := GetBlockSubsidy(block.Height)
+	if coinbaseValue+minted > subsidy+fees {
+		return ruleError(RejectBadCoinbaseValue, "block %x pays %d in rewards, more than subsidy %d plus fees %d", block.Hash, coinbaseValue+minted, subsidy, fees)
+	}
+
+	return nil
+}
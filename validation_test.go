@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTransactionSanityMoneyRange(t *testing.T) {
+	pubKeyHash := make([]byte, 20)
+	newTx := func(values ...int) *Transaction {
+		tx := &Transaction{nil, []TXInput{{Txid: []byte{1}, Vout: 0}}, nil, 0}
+		for _, value := range values {
+			tx.Vout = append(tx.Vout, TXOutput{Value: value, PubKeyHash: pubKeyHash})
+		}
+		tx.ID = tx.Hash()
+
+		return tx
+	}
+
+	assert.Nil(t, CheckTransactionSanity(newTx(MaxMoney)))
+	assert.NotNil(t, CheckTransactionSanity(newTx(MaxMoney+1)), "An output above MaxMoney")
+	assert.NotNil(t, CheckTransactionSanity(newTx(MaxMoney, 1)), "Outputs adding up to more than MaxMoney")
+	assert.NotNil(t, CheckTransactionSanity(newTx(math.MaxInt, math.MaxInt)), "Outputs whose sum overflows")
+	assert.NotNil(t, CheckTransactionSanity(newTx(-1)))
+}
+
+func TestValidateBlockRules(t *testing.T) {
+	params := MainNetParams
+	params.CoinbaseMaturity = 0
+	activeNetParams = &params
+	defer func() { activeNetParams = &MainNetParams }()
+
+	alice, bob := NewWallet(), NewWallet()
+	aliceAddress, bobAddress := string(alice.GetAddress()), string(bob.GetAddress())
+	bc := newTestBlockChain(t, aliceAddress)
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+
+	// spend sends the genesis coinbase to the address, once per input
+	spend := func(to string, inputs int) *Transaction {
+		tx := &Transaction{nil, nil, []TXOutput{*NewTXOutput(coinbase.Vout[0].Value, to)}, 0}
+		for i := 0; i < inputs; i++ {
+			tx.Vin = append(tx.Vin, TXInput{Txid: coinbase.ID, Vout: 0})
+		}
+		tx.ID = tx.Hash()
+		for i := range tx.Vin {
+			assert.Nil(t, alice.SignInput(tx, i, coinbase.Vout[0], SigHashAll))
+		}
+
+		return tx
+	}
+	newBlock := func(height int, bits uint32, transactions ...*Transaction) *Block {
+		return NewBlock(transactions, genesis.Hash, height, bits, genesis.Timestamp+1)
+	}
+	reseal := func(block *Block) *Block {
+		assert.Nil(t, ProofOfWorkEngine{}.Seal(context.Background(), nil, block, 1))
+		return block
+	}
+
+	tests := []struct {
+		name  string
+		block *Block
+		code  RejectCode
+	}{
+		{"bad merkle root", func() *Block {
+			block := newBlock(1, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 1, 0))
+			block.MerkleRoot = make([]byte, 32)
+			return reseal(block)
+		}(), RejectBadMerkleRoot},
+		{"hash above the target", func() *Block {
+			block := newBlock(1, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 1, 0))
+			for (ProofOfWorkEngine{}).VerifySeal(block) == nil {
+				block.Nonce++
+				block.Hash = block.BlockHeader.Hash()
+			}
+			return block
+		}(), RejectBadPoW},
+		{"bits other than required", newBlock(1, 0x1f00ffff, NewCoinbaseTX(aliceAddress, "", 1, 0)), RejectBadBits},
+		{"wrong height", newBlock(2, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 2, 0)), RejectBadHeight},
+		{"input spent twice in a transaction", newBlock(1, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 1, 0), spend(bobAddress, 2)), RejectDoubleSpend},
+		{"input spent by two transactions", newBlock(1, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 1, 0), spend(bobAddress, 1), spend(aliceAddress, 1)), RejectDoubleSpend},
+		{"coinbase above subsidy plus fees", newBlock(1, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 1, 1), spend(bobAddress, 1)), RejectBadCoinbaseValue},
+		{"first transaction not a coinbase", newBlock(1, params.GenesisBits, spend(bobAddress, 1), NewCoinbaseTX(aliceAddress, "", 1, 0)), RejectBadCoinbase},
+	}
+
+	for _, test := range tests {
+		err := bc.ValidateBlock(test.block)
+		if assert.IsType(t, RuleError{}, err, test.name) {
+			assert.Equal(t, test.code, err.(RuleError).Code, test.name)
+		}
+	}
+
+	valid := newBlock(1, params.GenesisBits, NewCoinbaseTX(aliceAddress, "", 1, 0), spend(bobAddress, 1))
+	assert.Nil(t, bc.ValidateBlock(valid))
+	assert.Nil(t, bc.AddBlock(valid))
+}
+
+func TestValidateBlockTimestamp(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc := newTestBlockChain(t, address)
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+
+	parent := &genesis
+	for height := 1; height <= 2; height++ {
+		block := NewBlock([]*Transaction{NewCoinbaseTX(address, "", height, 0)}, parent.Hash, height, activeNetParams.GenesisBits, genesis.Timestamp+int64(10*height))
+		assert.Nil(t, bc.AddBlock(block))
+		parent = block
+	}
+	medianTime := bc.MedianTimePast()
+	assert.Equal(t, genesis.Timestamp+10, medianTime)
+	maxTime := timeSource.AdjustedTime() + maxFutureBlockTime
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		code      RejectCode
+		valid     bool
+	}{
+		{"before the median time", medianTime - 1, RejectTimeTooOld, false},
+		{"at the median time", medianTime, RejectTimeTooOld, false},
+		{"after the median time", medianTime + 1, 0, true},
+		{"at the allowed drift", maxTime, 0, true},
+		{"beyond the allowed drift", maxTime + 60, RejectTimeTooNew, false},
+	}
+
+	for _, test := range tests {
+		block := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 3, 0)}, parent.Hash, 3, activeNetParams.GenesisBits, test.timestamp)
+		err := bc.ValidateBlock(block)
+		if test.valid {
+			assert.Nil(t, err, test.name)
+		} else if assert.IsType(t, RuleError{}, err, test.name) {
+			assert.Equal(t, test.code, err.(RuleError).Code, test.name)
+		}
+	}
+}
+
+func TestBlockCoinbaseMaturity(t *testing.T) {
+	params := MainNetParams
+	params.CoinbaseMaturity = 3
+	activeNetParams = &params
+	defer func() { activeNetParams = &MainNetParams }()
+
+	alice, bob := NewWallet(), NewWallet()
+	aliceAddress := string(alice.GetAddress())
+	bc := newTestBlockChain(t, aliceAddress)
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.Nil(t, err)
+
+	coinbase := genesis.Transactions[0]
+	spend := &Transaction{nil, []TXInput{{Txid: coinbase.ID, Vout: 0}}, []TXOutput{*NewTXOutput(coinbase.Vout[0].Value, string(bob.GetAddress()))}, 0}
+	spend.ID = spend.Hash()
+	assert.Nil(t, alice.SignInput(spend, 0, coinbase.Vout[0], SigHashAll))
+
+	b1 := NewBlock([]*Transaction{NewCoinbaseTX(aliceAddress, "", 1, 0)}, genesis.Hash, 1, params.GenesisBits, genesis.Timestamp+1)
+	assert.Nil(t, bc.AddBlock(b1))
+
+	immature := NewBlock([]*Transaction{NewCoinbaseTX(aliceAddress, "", 2, 0), spend}, b1.Hash, 2, params.GenesisBits, genesis.Timestamp+2)
+	err = bc.AddBlock(immature)
+	if assert.IsType(t, RuleError{}, err) {
+		assert.Equal(t, RejectImmatureCoinbase, err.(RuleError).Code, "At height 2 the genesis coinbase is one block short of maturity")
+	}
+
+	b2 := NewBlock([]*Transaction{NewCoinbaseTX(aliceAddress, "", 2, 0)}, b1.Hash, 2, params.GenesisBits, genesis.Timestamp+2)
+	assert.Nil(t, bc.AddBlock(b2))
+
+	mature := NewBlock([]*Transaction{NewCoinbaseTX(aliceAddress, "", 3, 0), spend}, b2.Hash, 3, params.GenesisBits, genesis.Timestamp+3)
+	assert.Nil(t, bc.AddBlock(mature), "The coinbase can be spent at exactly CoinbaseMaturity blocks")
+	assert.Equal(t, mature.Hash, bc.tip)
+}
@@ -6,13 +6,13 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"log"
+	"strings"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
-const ver = 1
-
 // const walletFile = "wallet.dat"
 const addressChecksumLen = 4
 
@@ -35,7 +35,19 @@ func NewWallet() *Wallet {
 func (w Wallet) GetAddress() []byte {
 	pubKeyHash := HashPubKey(w.PublicKey)
 
-	versionedPayload := append([]byte{ver}, pubKeyHash...)
+	return encodeAddress(activeNetParams.AddressVersion, pubKeyHash)
+}
+
+// ScriptAddress returns the pay-to-script-hash address of a redeem script.
+// Coins sent to it can be spent by revealing the redeem script and
+// satisfying it.
+func ScriptAddress(redeemScript []byte) []byte {
+	return encodeAddress(activeNetParams.ScriptAddressVersion, ScriptHash(redeemScript))
+}
+
+// encodeAddress encodes a hash with a version byte and a checksum
+func encodeAddress(version byte, hash []byte) []byte {
+	versionedPayload := append([]byte{version}, hash...)
 	checksum := checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -44,6 +56,22 @@ func (w Wallet) GetAddress() []byte {
 	return address
 }
 
+// SignInput signs the input at inIndex of the transaction, which spends the
+// output spent, with the wallet's key. The hash type selects the parts of the
+// transaction the signature covers, so others can still change the rest.
+// The input is set to the wallet's public key and the transaction ID is
+// recomputed, which leaves the signatures of the other inputs valid.
+func (w Wallet) SignInput(tx *Transaction, inIndex int, spent TXOutput, hashType SigHashType) error {
+	if inIndex < 0 || inIndex >= len(tx.Vin) {
+		return fmt.Errorf("transaction %x has no input %d", tx.ID, inIndex)
+	}
+
+	tx.Vin[inIndex].PubKey = w.PublicKey
+	tx.ID = tx.Hash()
+
+	return tx.SignInput(w.PrivateKey, inIndex, spent, hashType)
+}
+
 // HashPubKey hashes public key
 func HashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
@@ -58,15 +86,40 @@ func HashPubKey(pubKey []byte) []byte {
 	return publicRIPEMD160
 }
 
-// ValidateAddress check if address if valid
+// ValidateAddress check if address if valid on the active network. Both
+// pubkey hash and script hash addresses are valid.
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
-	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
-	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
+	version, _, ok := decodeAddress(address)
+
+	return ok && (version == activeNetParams.AddressVersion || version == activeNetParams.ScriptAddressVersion)
+}
+
+// IsScriptAddress reports whether the address is a valid pay-to-script-hash
+// address on the active network
+func IsScriptAddress(address string) bool {
+	version, _, ok := decodeAddress(address)
+
+	return ok && version == activeNetParams.ScriptAddressVersion
+}
+
+// decodeAddress returns the version byte and the hash of an address, ok is
+// false when its checksum does not match
+func decodeAddress(address string) (version byte, hash []byte, ok bool) {
+	if len(address) == 0 || strings.Trim(address, string(b58Alphabet)) != "" {
+		return 0, nil, false
+	}
+
+	payload := Base58Decode([]byte(address))
+	if len(payload) <= 1+addressChecksumLen {
+		return 0, nil, false
+	}
+
+	actualChecksum := payload[len(payload)-addressChecksumLen:]
+	version = payload[0]
+	hash = payload[1 : len(payload)-addressChecksumLen]
+	targetChecksum := checksum(append([]byte{version}, hash...))
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	return version, hash, bytes.Compare(actualChecksum, targetChecksum) == 0
 }
 
 // Checksum generates a checksum for a public key
@@ -83,7 +136,10 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// Both coordinates take 32 bytes, so that the key can be split in halves
+	pubKey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pubKey[:32])
+	private.PublicKey.Y.FillBytes(pubKey[32:])
 
 	return *private, pubKey
 }
@@ -11,12 +11,12 @@ import (
 	"sync"
 )
 
-const walletFile = "wallet_%s.dat"
-
 // Wallets stores a collection of wallets
 type Wallets struct {
 	Wallets map[string]*Wallet
 	Alias   map[string]string
+	// MultiSig holds the multisig addresses the node's keyholders share
+	MultiSig map[string]*MultiSigWallet
 }
 
 var instance *Wallets
@@ -40,6 +40,7 @@ func newWallets(nodeID string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
 	wallets.Alias = make(map[string]string)
+	wallets.MultiSig = make(map[string]*MultiSigWallet)
 
 	err := wallets.LoadFromFile(nodeID)
 
@@ -74,7 +75,7 @@ func (ws Wallets) GetWallet(address string) Wallet {
 
 // LoadFromFile loads wallets from the file
 func (ws *Wallets) LoadFromFile(nodeID string) error {
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := fmt.Sprintf(activeNetParams.WalletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		ws.SaveToFile(nodeID)
 		return err
@@ -95,6 +96,9 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 
 	ws.Wallets = wallets.Wallets
 	ws.Alias = wallets.Alias
+	if wallets.MultiSig != nil {
+		ws.MultiSig = wallets.MultiSig
+	}
 
 	return nil
 }
@@ -102,7 +106,7 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 // SaveToFile saves wallets to a file
 func (ws Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := fmt.Sprintf(activeNetParams.WalletFile, nodeID)
 
 	gob.Register(elliptic.P256())
 
@@ -140,6 +144,33 @@ func (ws *Wallets) AddWallet(alias string) string {
 
 }
 
+// AddMultiSig adds an m-of-n multisig address of the public keys to Wallets
+func (ws *Wallets) AddMultiSig(required int, pubKeys [][]byte, alias string) (string, error) {
+	wallet, err := NewMultiSigWallet(required, pubKeys)
+	if err != nil {
+		return "", err
+	}
+
+	address := string(wallet.GetAddress())
+
+	if alias == "" {
+		alias = address
+	}
+	if existing, exists := ws.Alias[alias]; exists && existing != address {
+		return "", fmt.Errorf("alias %s already exists", alias)
+	}
+
+	ws.MultiSig[address] = wallet
+	ws.Alias[alias] = address
+
+	return address, nil
+}
+
+// GetMultiSig returns the multisig wallet of an address, or nil
+func (ws *Wallets) GetMultiSig(address string) *MultiSigWallet {
+	return ws.MultiSig[address]
+}
+
 func (ws *Wallets) GetAddress(alias string) string {
 	address, exists := ws.Alias[alias]
 